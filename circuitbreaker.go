@@ -0,0 +1,207 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// breakerState 描述熔断器的三种状态
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // 关闭：正常放行，统计失败率
+	breakerOpen                         // 打开：直接拒绝，不再调用上游
+	breakerHalfOpen                     // 半开：放行少量探测请求
+)
+
+// circuitBreaker 是单个路由的熔断状态机，Closed 状态下在滑动窗口内统计失败率，
+// 超过阈值则转为 Open；冷却到期后进入 Half-Open 放行少量探测请求，
+// 探测全部成功则恢复 Closed，否则重新回到 Open。
+type circuitBreaker struct {
+	cfg  BreakerConfig
+	path string // 所属路由路径，仅用于日志与指标标签
+	mu   sync.Mutex
+
+	state breakerState
+
+	windowStart time.Time // Closed 状态下当前统计窗口的起始时间
+	total       int       // 当前窗口内的请求总数
+	failures    int       // 当前窗口内的失败数
+
+	openedAt          time.Time // 进入 Open 状态的时间
+	halfOpenProbes    int       // Half-Open 状态下已放行的探测数
+	halfOpenSuccesses int       // Half-Open 状态下探测成功数
+
+	generation uint64 // 每次状态切换都会递增，用于识别滞后到达的过期结果
+}
+
+// newCircuitBreaker 创建一个初始状态为 Closed 的熔断器。
+func newCircuitBreaker(cfg BreakerConfig, path string) *circuitBreaker {
+	return &circuitBreaker{cfg: cfg, path: path, windowStart: time.Now()}
+}
+
+// Allow 判断请求是否可以放行；Open 状态下冷却到期会自动转入 Half-Open。
+// 返回值 generation 标识本次放行发生时熔断器所处的状态世代，调用方必须
+// 原样传回 RecordResult，以便丢弃状态已经切换之后才姗姗来迟的结果。
+func (cb *circuitBreaker) Allow() (bool, uint64) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == breakerOpen {
+		if time.Since(cb.openedAt) < cb.cfg.OpenDuration {
+			return false, cb.generation
+		}
+		cb.toHalfOpenLocked()
+	}
+
+	if cb.state == breakerHalfOpen {
+		if cb.halfOpenProbes >= cb.cfg.HalfOpenMaxProbes {
+			return false, cb.generation
+		}
+		cb.halfOpenProbes++
+		return true, cb.generation
+	}
+
+	return true, cb.generation
+}
+
+// RecordResult 记录一次请求的结果（success 为 true 表示非 5xx 且未出错）。
+// generation 必须是发出这次请求时 Allow 返回的世代号：如果熔断器在请求
+// 处理期间已经发生了状态切换（例如并发的另一个 Half-Open 探测先失败，把
+// 熔断器打回了 Open），本次结果已经过时，直接丢弃——否则它会被计入新状态
+// 的统计，导致 Open 的冷却时间被不断重置，熔断器永远无法恢复。
+func (cb *circuitBreaker) RecordResult(success bool, generation uint64) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if generation != cb.generation {
+		return
+	}
+
+	if cb.state == breakerHalfOpen {
+		if !success {
+			cb.toOpenLocked()
+			return
+		}
+		cb.halfOpenSuccesses++
+		if cb.halfOpenSuccesses >= cb.cfg.HalfOpenMaxProbes {
+			cb.toClosedLocked()
+		}
+		return
+	}
+
+	cb.recordWindowLocked(success)
+}
+
+// recordWindowLocked 在 Closed 状态下把本次结果计入滑动窗口，
+// 窗口内请求数达到 MinRequests 后才评估失败率是否超过 FailureRatio。
+func (cb *circuitBreaker) recordWindowLocked(success bool) {
+	now := time.Now()
+	if now.Sub(cb.windowStart) > cb.cfg.Window {
+		cb.windowStart = now
+		cb.total = 0
+		cb.failures = 0
+	}
+
+	cb.total++
+	if !success {
+		cb.failures++
+	}
+
+	if cb.total >= cb.cfg.MinRequests && float64(cb.failures)/float64(cb.total) >= cb.cfg.FailureRatio {
+		cb.toOpenLocked()
+	}
+}
+
+func (cb *circuitBreaker) toOpenLocked() {
+	cb.state = breakerOpen
+	cb.openedAt = time.Now()
+	cb.halfOpenProbes = 0
+	cb.halfOpenSuccesses = 0
+	cb.generation++
+	breakerStateChanges.WithLabelValues(cb.path, "open").Inc()
+	log.Printf("[熔断] 路由 %s 的熔断器进入 Open 状态，冷却 %s", cb.path, cb.cfg.OpenDuration)
+}
+
+func (cb *circuitBreaker) toHalfOpenLocked() {
+	cb.state = breakerHalfOpen
+	cb.halfOpenProbes = 0
+	cb.halfOpenSuccesses = 0
+	cb.generation++
+	breakerStateChanges.WithLabelValues(cb.path, "half_open").Inc()
+	log.Printf("[熔断] 路由 %s 的熔断器进入 Half-Open 状态，放行 %d 个探测请求", cb.path, cb.cfg.HalfOpenMaxProbes)
+}
+
+func (cb *circuitBreaker) toClosedLocked() {
+	cb.state = breakerClosed
+	cb.windowStart = time.Now()
+	cb.total = 0
+	cb.failures = 0
+	cb.generation++
+	breakerStateChanges.WithLabelValues(cb.path, "closed").Inc()
+	log.Printf("[熔断] 路由 %s 的熔断器恢复 Closed 状态", cb.path)
+}
+
+// retryAfterSeconds 返回 Open 状态下距离可以进入 Half-Open 探测还需等待的秒数。
+func (cb *circuitBreaker) retryAfterSeconds() int {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	remaining := cb.cfg.OpenDuration - time.Since(cb.openedAt)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return int(math.Ceil(remaining.Seconds()))
+}
+
+func buildBreakers(config *Config) map[string]*circuitBreaker {
+	breakers := make(map[string]*circuitBreaker)
+	for _, route := range config.Routes {
+		if route.Breaker != nil {
+			breakers[route.Path] = newCircuitBreaker(*route.Breaker, route.Path)
+		}
+	}
+	return breakers
+}
+
+// CircuitBreakerMiddleware 返回一个按路由熔断上游故障的中间件。
+// 只有配置了 breaker 的路由才会被纳入熔断统计，未配置的路由直接放行。
+// 参数 cm 提供当前生效的配置快照；熔断器状态只在配置热更新后才会重建
+// （意味着热更新会重置所有路由的熔断统计与状态）。
+func CircuitBreakerMiddleware(cm *ConfigManager) Middleware {
+	var cache cachedDerived[map[string]*circuitBreaker]
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			breakers := cache.get(cm.Current(), buildBreakers)
+
+			matched, ok := matchedRouteFrom(r)
+			if !ok {
+				next(w, r)
+				return
+			}
+
+			cb, ok := breakers[matched.Route.Path]
+			if !ok {
+				next(w, r)
+				return
+			}
+
+			allowed, generation := cb.Allow()
+			if !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(cb.retryAfterSeconds()))
+				http.Error(w, "503 Service Unavailable: upstream circuit open", http.StatusServiceUnavailable)
+				log.Printf("[熔断] %s %s | 熔断器处于 Open 状态，拒绝请求", r.Method, r.URL.Path)
+				return
+			}
+
+			wrapped := &ResponseWriterWrapper{ResponseWriter: w, StatusCode: http.StatusOK}
+			next(wrapped, r)
+			cb.RecordResult(wrapped.StatusCode < http.StatusInternalServerError, generation)
+		}
+	}
+}
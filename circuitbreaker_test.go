@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func testBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		Window:            time.Minute,
+		MinRequests:       4,
+		FailureRatio:      0.5,
+		OpenDuration:      20 * time.Millisecond,
+		HalfOpenMaxProbes: 2,
+	}
+}
+
+// TestCircuitBreakerClosedToOpen 验证 Closed 状态下窗口内请求数达到 MinRequests
+// 且失败率超过 FailureRatio 后会转入 Open，且 Open 状态下直接拒绝请求。
+func TestCircuitBreakerClosedToOpen(t *testing.T) {
+	cb := newCircuitBreaker(testBreakerConfig(), "/svc")
+
+	allowed, gen := cb.Allow()
+	if !allowed {
+		t.Fatal("Closed 状态下应放行请求")
+	}
+	cb.RecordResult(true, gen)
+	cb.RecordResult(false, gen)
+	cb.RecordResult(false, gen)
+	cb.RecordResult(false, gen)
+
+	if cb.state != breakerOpen {
+		t.Fatalf("失败率 3/4 超过阈值 0.5，应转入 Open，实际状态 %v", cb.state)
+	}
+	if allowed, _ := cb.Allow(); allowed {
+		t.Fatal("Open 状态下冷却时间未到，应拒绝请求")
+	}
+}
+
+// TestCircuitBreakerOpenToHalfOpenToClosed 验证 Open 状态冷却到期后自动转入
+// Half-Open，探测全部成功后恢复 Closed。
+func TestCircuitBreakerOpenToHalfOpenToClosed(t *testing.T) {
+	cfg := testBreakerConfig()
+	cb := newCircuitBreaker(cfg, "/svc")
+	cb.toOpenLocked()
+
+	time.Sleep(cfg.OpenDuration + 5*time.Millisecond)
+
+	allowed, gen1 := cb.Allow()
+	if !allowed {
+		t.Fatal("冷却到期后应转入 Half-Open 并放行第一个探测请求")
+	}
+	if cb.state != breakerHalfOpen {
+		t.Fatalf("冷却到期后应进入 Half-Open，实际状态 %v", cb.state)
+	}
+
+	cb.RecordResult(true, gen1)
+	allowed, gen2 := cb.Allow()
+	if !allowed {
+		t.Fatal("Half-Open 探测配额未用完，应继续放行")
+	}
+	cb.RecordResult(true, gen2)
+
+	if cb.state != breakerClosed {
+		t.Fatalf("HalfOpenMaxProbes 次探测全部成功后应恢复 Closed，实际状态 %v", cb.state)
+	}
+}
+
+// TestCircuitBreakerHalfOpenFailureReopens 验证 Half-Open 状态下任意一次探测
+// 失败都会立刻重新进入 Open。
+func TestCircuitBreakerHalfOpenFailureReopens(t *testing.T) {
+	cfg := testBreakerConfig()
+	cb := newCircuitBreaker(cfg, "/svc")
+	cb.toHalfOpenLocked()
+	gen := cb.generation
+
+	cb.RecordResult(false, gen)
+
+	if cb.state != breakerOpen {
+		t.Fatalf("Half-Open 探测失败应重新进入 Open，实际状态 %v", cb.state)
+	}
+}
+
+// TestCircuitBreakerHalfOpenProbeLimit 验证 Half-Open 状态下超过
+// HalfOpenMaxProbes 的请求会被拒绝，而不是无限放行。
+func TestCircuitBreakerHalfOpenProbeLimit(t *testing.T) {
+	cfg := testBreakerConfig()
+	cb := newCircuitBreaker(cfg, "/svc")
+	cb.toHalfOpenLocked()
+
+	for i := 0; i < cfg.HalfOpenMaxProbes; i++ {
+		if allowed, _ := cb.Allow(); !allowed {
+			t.Fatalf("第 %d 个探测请求应被放行", i+1)
+		}
+	}
+	if allowed, _ := cb.Allow(); allowed {
+		t.Fatal("超过 HalfOpenMaxProbes 的探测请求应被拒绝")
+	}
+}
+
+// TestCircuitBreakerStaleHalfOpenResultDiscarded 回归测试：两个并发 Half-Open
+// 探测中，先返回的一个失败把熔断器重新打回 Open（开启新的 openedAt/冷却计时）；
+// 后返回的另一个探测结果（无论成功还是失败）都属于已经过期的世代，必须被
+// RecordResult 丢弃，不能再被计入新 Open 周期的统计，否则会不断重置冷却时间，
+// 导致熔断器无法按 OpenDuration 正常恢复。
+func TestCircuitBreakerStaleHalfOpenResultDiscarded(t *testing.T) {
+	cfg := testBreakerConfig()
+	cfg.HalfOpenMaxProbes = 2
+	cb := newCircuitBreaker(cfg, "/svc")
+	cb.toHalfOpenLocked()
+
+	allowed1, gen1 := cb.Allow()
+	allowed2, gen2 := cb.Allow()
+	if !allowed1 || !allowed2 {
+		t.Fatal("两个探测请求都应在配额内被放行")
+	}
+	if gen1 != gen2 {
+		t.Fatal("同一 Half-Open 周期内放行的探测应共享同一个 generation")
+	}
+
+	// 第一个探测先返回，失败，把熔断器重新打回 Open 并开启新的冷却计时。
+	cb.RecordResult(false, gen1)
+	if cb.state != breakerOpen {
+		t.Fatalf("探测失败应重新进入 Open，实际状态 %v", cb.state)
+	}
+	reopenedAt := cb.openedAt
+
+	time.Sleep(2 * time.Millisecond)
+
+	// 第二个探测这时候才返回（结果是什么不重要），它属于已经失效的世代，
+	// 不应再影响当前 Open 周期的状态或重置冷却时间。
+	cb.RecordResult(true, gen2)
+
+	if cb.state != breakerOpen {
+		t.Fatalf("过期的探测结果不应改变当前状态，实际状态 %v", cb.state)
+	}
+	if !cb.openedAt.Equal(reopenedAt) {
+		t.Fatal("过期的探测结果不应重置 Open 状态的冷却计时")
+	}
+}
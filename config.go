@@ -13,24 +13,152 @@ import (
 // NoAuthRoutes: 无需身份验证的路由路径列表
 // Routes: 路由配置列表，包含路径映射和转发目标
 // GlobalRateLimit: 全局速率限制配置
+// RateLimitKey: 限流的默认维度（global/ip/apikey，或自定义请求头名）
+// NoRateLimitRoutes: 无需限流的路由路径列表（如 /metrics）
 // Timeout: 请求超时时间
+// Auth: 认证相关配置（目前仅 JWT；API Key 沿用 ApiKeys/NoAuthRoutes）
+// TrustedProxies: 部署在本网关前面的反向代理/负载均衡器地址列表。只有直连
+// 对端地址命中该列表时，按 "ip" 维度限流才会信任其 X-Forwarded-For 声明，
+// 否则一律使用 TCP 连接的真实对端地址，避免客户端伪造该请求头绕过限流
 type Config struct {
-	Port            string                `yaml:"port"`
-	ApiKeys         []string              `yaml:"apiKeys"`
-	NoAuthRoutes    []string              `yaml:"noAuthRoutes"`
-	Routes          []RouteConfig         `yaml:"routes"`
-	GlobalRateLimit GlobalRateLimitConfig `yaml:"globalRateLimit"`
-	Timeout         time.Duration         `yaml:"timeout"`
+	Port              string                `yaml:"port"`
+	ApiKeys           []string              `yaml:"apiKeys"`
+	NoAuthRoutes      []string              `yaml:"noAuthRoutes"`
+	Routes            []RouteConfig         `yaml:"routes"`
+	GlobalRateLimit   GlobalRateLimitConfig `yaml:"globalRateLimit"`
+	RateLimitKey      string                `yaml:"rateLimitKey"`
+	NoRateLimitRoutes []string              `yaml:"noRateLimitRoutes"`
+	Timeout           time.Duration         `yaml:"timeout"`
+	Auth              AuthConfig            `yaml:"auth"`
+	TrustedProxies    []string              `yaml:"trustedProxies"`
+}
+
+// AuthConfig 认证相关的全局配置。
+type AuthConfig struct {
+	JWT *JWTConfig `yaml:"jwt"`
+}
+
+// JWTConfig JWT Bearer 认证配置
+// Secret: HMAC 签名密钥，用于 HS256/384/512
+// JWKS: 非对称签名（RS256/ES256）时用于拉取公钥的 JWKS 配置
+// Issuer: 期望的 iss claim，留空则不校验
+// Audience: 默认的 aud claim，路由可通过 RouteConfig.Audience 覆盖
+// ClaimHeaders: claim 名到下游请求头的映射，例如 sub -> X-User-ID
+type JWTConfig struct {
+	Secret       string            `yaml:"secret"`
+	JWKS         *JWKSConfig       `yaml:"jwks"`
+	Issuer       string            `yaml:"issuer"`
+	Audience     string            `yaml:"audience"`
+	ClaimHeaders map[string]string `yaml:"claimHeaders"`
+}
+
+// JWKSConfig 远程 JWKS 端点配置
+// URL: JWKS 端点地址
+// RefreshInterval: 后台定期刷新间隔，默认 5 分钟
+type JWKSConfig struct {
+	URL             string        `yaml:"url"`
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
 }
 
 // RouteConfig 路由配置结构体，定义了单个路由的转发规则
 // Path: 请求路径
-// Target: 转发目标地址
-// QPS: 每秒查询率限制
+// Target: 转发目标地址，可以是单个 URL 或 URL 列表（用于负载均衡）
+// QPS: 每秒查询率限制（旧版配置，RateLimit 存在时优先使用 RateLimit）
+// RateLimit: 基于 golang.org/x/time/rate 的限流配置
+// LoadBalance: 多个 Target 间的负载均衡策略：round_robin/random/least_conn/ip_hash
+// HealthCheck: 上游健康检查配置
+// Methods: 允许匹配该路由的 HTTP 方法列表，为空表示匹配任意方法
+// AuthScheme: 该路由使用的认证方式：none/apikey/jwt/any，留空等价于 apikey
+// Audience: 覆盖 Auth.JWT.Audience，对该路由的 JWT 校验使用此 aud
 type RouteConfig struct {
-	Path   string `yaml:"path"`
-	Target string `yaml:"target"`
-	QPS    int    `yaml:"qps"`
+	Path        string             `yaml:"path"`
+	Target      Targets            `yaml:"target"`
+	QPS         int                `yaml:"qps"`
+	RateLimit   *RateLimitConfig   `yaml:"rateLimit"`
+	Breaker     *BreakerConfig     `yaml:"breaker"`
+	LoadBalance string             `yaml:"loadBalance"`
+	HealthCheck *HealthCheckConfig `yaml:"healthCheck"`
+	Methods     []string           `yaml:"methods"`
+	AuthScheme  string             `yaml:"authScheme"`
+	Audience    string             `yaml:"audience"`
+}
+
+// Targets 既可以在 YAML 中写成单个字符串，也可以写成字符串列表，
+// 以兼容只有一个上游的历史配置，同时支持多上游负载均衡。
+type Targets []string
+
+// UnmarshalYAML 优先按单个字符串解析，失败再按字符串列表解析。
+func (t *Targets) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*t = Targets{single}
+		return nil
+	}
+
+	var multi []string
+	if err := unmarshal(&multi); err != nil {
+		return err
+	}
+	*t = Targets(multi)
+	return nil
+}
+
+// HealthCheckConfig 上游健康检查配置
+// Path: 健康检查探测路径
+// Interval: 探测间隔
+// HealthyThreshold: 连续探测成功多少次后才将上游重新标记为健康
+type HealthCheckConfig struct {
+	Path             string        `yaml:"path"`
+	Interval         time.Duration `yaml:"interval"`
+	HealthyThreshold int           `yaml:"healthyThreshold"`
+}
+
+// BreakerConfig 单个路由的熔断配置
+// Window: 滑动窗口时长，超过该时长未更新的统计会被重置
+// MinRequests: 窗口内达到该请求数后才会评估是否熔断
+// FailureRatio: 失败率阈值，超过该比例则从 Closed 切换为 Open
+// OpenDuration: Open 状态的冷却时长，到期后进入 Half-Open 探测
+// HalfOpenMaxProbes: Half-Open 状态下允许放行的探测请求数
+type BreakerConfig struct {
+	Window            time.Duration `yaml:"window"`
+	MinRequests       int           `yaml:"minRequests"`
+	FailureRatio      float64       `yaml:"failureRatio"`
+	OpenDuration      time.Duration `yaml:"openDuration"`
+	HalfOpenMaxProbes int           `yaml:"halfOpenMaxProbes"`
+}
+
+// applyBreakerDefaults 给零值字段填充合理默认值，和 HealthCheckConfig 的
+// 默认值套路一样：运维写 `breaker: {}` 只是想"用默认阈值开启熔断"，不应该
+// 因为 FailureRatio/HalfOpenMaxProbes 零值巧合地意味着"窗口内一次请求就
+// 触发熔断""且永远走不出 Half-Open"，导致路由被永久 503。breaker 为 nil
+// （未配置熔断）时什么也不做。
+func applyBreakerDefaults(b *BreakerConfig) {
+	if b == nil {
+		return
+	}
+	if b.Window == 0 {
+		b.Window = 30 * time.Second
+	}
+	if b.MinRequests == 0 {
+		b.MinRequests = 1
+	}
+	if b.FailureRatio == 0 {
+		b.FailureRatio = 1 // 未显式配置相当于关闭熔断：只有 100% 失败才会触发
+	}
+	if b.OpenDuration == 0 {
+		b.OpenDuration = 30 * time.Second
+	}
+	if b.HalfOpenMaxProbes == 0 {
+		b.HalfOpenMaxProbes = 1
+	}
+}
+
+// RateLimitConfig 单个路由的限流配置
+// Rate: 每秒生成的令牌数（支持小数，如 0.5 表示每两秒一个令牌）
+// Burst: 令牌桶容量，允许的瞬时突发请求数
+type RateLimitConfig struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
 }
 
 // GlobalRateLimitConfig 全局速率限制配置结构体
@@ -69,6 +197,9 @@ func loadConfig(filepath string) (*Config, error) {
 	if config.Timeout == 0 {
 		config.Timeout = 1 * time.Second
 	}
+	for i := range config.Routes {
+		applyBreakerDefaults(config.Routes[i].Breaker)
+	}
 
 	return &config, nil
 }
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ConfigManager 把 *Config 包装在 atomic.Pointer 之后，支持运行时热更新：
+// 监听 config.yaml 的文件变更（fsnotify）以及 SIGHUP 信号，变更时重新解析、
+// 校验并原子替换当前配置，中间件只需在每次请求时调用 Current() 读取最新快照。
+type ConfigManager struct {
+	path    string
+	current atomic.Pointer[Config]
+}
+
+// NewConfigManager 加载初始配置并启动后台热加载监听。
+func NewConfigManager(path string) (*ConfigManager, error) {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cm := &ConfigManager{path: path}
+	cm.current.Store(cfg)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("创建配置文件监听器失败: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("监听配置文件目录失败: %w", err)
+	}
+
+	go cm.watchFile(watcher)
+	go cm.watchSignal()
+
+	return cm, nil
+}
+
+// Current 返回当前生效的配置快照，可安全并发调用。
+func (cm *ConfigManager) Current() *Config {
+	return cm.current.Load()
+}
+
+// watchFile 监听 config.yaml 所在目录，文件发生写入/创建（含编辑器的
+// 先写临时文件再原子重命名）时触发一次重新加载。
+func (cm *ConfigManager) watchFile(watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+	name := filepath.Base(cm.path)
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Base(event.Name) != name {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			cm.reload("文件变更")
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("[配置热加载] 监听出错: %v", err)
+		}
+	}
+}
+
+// watchSignal 以 SIGHUP 作为文件监听之外的兜底触发方式。
+func (cm *ConfigManager) watchSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	for range sigs {
+		cm.reload("SIGHUP")
+	}
+}
+
+// reload 重新解析、校验 config.yaml，校验通过后原子替换当前配置并打印差异摘要；
+// 任何一步失败都会保留旧配置不变。
+func (cm *ConfigManager) reload(trigger string) {
+	newCfg, err := loadConfig(cm.path)
+	if err != nil {
+		log.Printf("[配置热加载] (%s) 重新加载失败，继续使用旧配置: %v", trigger, err)
+		return
+	}
+	if err := validateConfig(newCfg); err != nil {
+		log.Printf("[配置热加载] (%s) 配置校验失败，继续使用旧配置: %v", trigger, err)
+		return
+	}
+
+	old := cm.current.Swap(newCfg)
+	log.Printf("[配置热加载] (%s) 配置已更新 | %s", trigger, diffSummary(old, newCfg))
+}
+
+// validateConfig 对新加载的配置做基本合法性检查：端口非空、(路径, 方法) 不重复、
+// 目标地址可解析、QPS 非负、breaker 各阈值在合法范围内。
+func validateConfig(cfg *Config) error {
+	if cfg.Port == "" {
+		return fmt.Errorf("port 不能为空")
+	}
+
+	// 按 Router.Match 实际采用的规则判断冲突：同一路径下，methods 为空时
+	// 等价于单个 "*" 方法，只有 (路径, 方法) 完全相同的两条路由才会互相覆盖
+	// ——路径相同但方法不同（含 "*" 与具体方法并存）是受支持的配置，不算重复。
+	seenKeys := make(map[string]struct{}, len(cfg.Routes))
+	for _, route := range cfg.Routes {
+		methods := route.Methods
+		if len(methods) == 0 {
+			methods = []string{"*"}
+		}
+		for _, m := range methods {
+			key := route.Path + "|" + strings.ToUpper(m)
+			if _, dup := seenKeys[key]; dup {
+				return fmt.Errorf("路由路径重复: %s %s", strings.ToUpper(m), route.Path)
+			}
+			seenKeys[key] = struct{}{}
+		}
+
+		if route.QPS < 0 {
+			return fmt.Errorf("路由 %s 的 qps 不能为负数", route.Path)
+		}
+		switch route.AuthScheme {
+		case "", "none", "apikey", "jwt", "any":
+		default:
+			return fmt.Errorf("路由 %s 的 authScheme 非法: %s", route.Path, route.AuthScheme)
+		}
+		for _, target := range route.Target {
+			if _, err := url.Parse(target); err != nil {
+				return fmt.Errorf("路由 %s 的目标地址 %q 非法: %w", route.Path, target, err)
+			}
+		}
+
+		if b := route.Breaker; b != nil {
+			if b.MinRequests < 1 {
+				return fmt.Errorf("路由 %s 的 breaker.minRequests 必须 >= 1", route.Path)
+			}
+			if b.HalfOpenMaxProbes < 1 {
+				return fmt.Errorf("路由 %s 的 breaker.halfOpenMaxProbes 必须 >= 1", route.Path)
+			}
+			if b.FailureRatio <= 0 || b.FailureRatio > 1 {
+				return fmt.Errorf("路由 %s 的 breaker.failureRatio 必须在 (0, 1] 区间内", route.Path)
+			}
+			if b.Window <= 0 {
+				return fmt.Errorf("路由 %s 的 breaker.window 必须为正数", route.Path)
+			}
+			if b.OpenDuration <= 0 {
+				return fmt.Errorf("路由 %s 的 breaker.openDuration 必须为正数", route.Path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// diffSummary 生成一段便于运维排查的配置差异摘要，只对比高层级、易出错的字段，
+// 不做逐字段深度比较。
+func diffSummary(old, new *Config) string {
+	var changes []string
+
+	if old.Port != new.Port {
+		changes = append(changes, fmt.Sprintf("port: %s -> %s", old.Port, new.Port))
+	}
+	if len(old.Routes) != len(new.Routes) {
+		changes = append(changes, fmt.Sprintf("路由数: %d -> %d", len(old.Routes), len(new.Routes)))
+	}
+	if len(old.ApiKeys) != len(new.ApiKeys) {
+		changes = append(changes, fmt.Sprintf("ApiKeys 数: %d -> %d", len(old.ApiKeys), len(new.ApiKeys)))
+	}
+	if old.GlobalRateLimit != new.GlobalRateLimit {
+		changes = append(changes, fmt.Sprintf("globalRateLimit: %+v -> %+v", old.GlobalRateLimit, new.GlobalRateLimit))
+	}
+	if old.Timeout != new.Timeout {
+		changes = append(changes, fmt.Sprintf("timeout: %s -> %s", old.Timeout, new.Timeout))
+	}
+
+	if len(changes) == 0 {
+		return "无明显差异"
+	}
+	return strings.Join(changes, "; ")
+}
+
+// cachedDerived 缓存某个从 *Config 派生出的结构体，只有当配置快照指针发生变化
+// （即发生过一次热加载）时才重新构建，避免每个请求都重复计算 map 等派生状态。
+type cachedDerived[T any] struct {
+	built atomic.Pointer[derivedEntry[T]]
+}
+
+type derivedEntry[T any] struct {
+	cfg   *Config
+	value T
+}
+
+// get 返回 cfg 对应的派生值；cfg 指针与上次不同（配置已热更新）时会调用 build 重建。
+// 被替换下来的旧值如果实现了 interface{ Stop() }（例如持有后台 goroutine 的
+// limiterRegistry），会在替换后调用一次 Stop，避免每次热重载都泄漏一个协程。
+func (c *cachedDerived[T]) get(cfg *Config, build func(*Config) T) T {
+	if entry := c.built.Load(); entry != nil && entry.cfg == cfg {
+		return entry.value
+	}
+	entry := &derivedEntry[T]{cfg: cfg, value: build(cfg)}
+	old := c.built.Swap(entry)
+	if old != nil {
+		if stopper, ok := any(old.value).(interface{ Stop() }); ok {
+			stopper.Stop()
+		}
+	}
+	return entry.value
+}
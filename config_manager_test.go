@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestValidateConfigAllowsSamePathDifferentMethods 回归测试：同一路径配置
+// 多条不同 HTTP 方法的路由（chunk0-5 引入的按方法路由）是合法配置，不应被
+// validateConfig 当作路径重复拒绝。
+func TestValidateConfigAllowsSamePathDifferentMethods(t *testing.T) {
+	cfg := &Config{
+		Port: ":8082",
+		Routes: []RouteConfig{
+			{Path: "/users", Methods: []string{"GET"}, Target: Targets{"http://a"}},
+			{Path: "/users", Methods: []string{"POST"}, Target: Targets{"http://a"}},
+			{Path: "/ping", Target: Targets{"http://a"}}, // 无 Methods，等价于 "*"
+		},
+	}
+
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("同路径不同 method 应被允许，却返回了错误: %v", err)
+	}
+}
+
+// TestValidateConfigRejectsSamePathAndMethod 验证真正的重复（同一路径、
+// 同一方法，或都为默认的 "*"）仍然会被拒绝。
+func TestValidateConfigRejectsSamePathAndMethod(t *testing.T) {
+	cfg := &Config{
+		Port: ":8082",
+		Routes: []RouteConfig{
+			{Path: "/users", Methods: []string{"GET"}, Target: Targets{"http://a"}},
+			{Path: "/users", Methods: []string{"GET"}, Target: Targets{"http://b"}},
+		},
+	}
+
+	if err := validateConfig(cfg); err == nil {
+		t.Fatal("同路径同 method 重复应被拒绝，却返回了 nil")
+	}
+}
+
+// TestValidateConfigRejectsInvalidBreakerThresholds 验证 breaker 各阈值超出
+// 合法范围（MinRequests/HalfOpenMaxProbes < 1、FailureRatio 不在 (0,1]、
+// Window/OpenDuration 非正）时会被拒绝；Breaker 为 nil（未配置熔断）不受影响。
+func TestValidateConfigRejectsInvalidBreakerThresholds(t *testing.T) {
+	base := BreakerConfig{
+		Window:            time.Second,
+		MinRequests:       1,
+		FailureRatio:      0.5,
+		OpenDuration:      time.Second,
+		HalfOpenMaxProbes: 1,
+	}
+
+	cases := []struct {
+		name    string
+		mutate  func(b *BreakerConfig)
+	}{
+		{"MinRequests<1", func(b *BreakerConfig) { b.MinRequests = 0 }},
+		{"HalfOpenMaxProbes<1", func(b *BreakerConfig) { b.HalfOpenMaxProbes = 0 }},
+		{"FailureRatio<=0", func(b *BreakerConfig) { b.FailureRatio = 0 }},
+		{"FailureRatio>1", func(b *BreakerConfig) { b.FailureRatio = 1.5 }},
+		{"Window<=0", func(b *BreakerConfig) { b.Window = 0 }},
+		{"OpenDuration<=0", func(b *BreakerConfig) { b.OpenDuration = 0 }},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			breaker := base
+			tc.mutate(&breaker)
+			cfg := &Config{
+				Port: ":8082",
+				Routes: []RouteConfig{
+					{Path: "/users", Target: Targets{"http://a"}, Breaker: &breaker},
+				},
+			}
+			if err := validateConfig(cfg); err == nil {
+				t.Fatalf("非法 breaker 配置（%s）应被拒绝，却返回了 nil", tc.name)
+			}
+		})
+	}
+}
+
+// TestValidateConfigAllowsNilBreaker 验证未配置 Breaker 的路由不受新增校验影响。
+func TestValidateConfigAllowsNilBreaker(t *testing.T) {
+	cfg := &Config{
+		Port: ":8082",
+		Routes: []RouteConfig{
+			{Path: "/ping", Target: Targets{"http://a"}},
+		},
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("未配置 breaker 的路由不应受影响，却返回了错误: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// TestApplyBreakerDefaultsFillsZeroValue 验证零值 BreakerConfig{}（即
+// `breaker: {}`）会被填充为"实质上关闭"的合理默认值，而不是在第一次记录结果
+// 时就因为 FailureRatio/HalfOpenMaxProbes 零值巧合而永久触发熔断。
+func TestApplyBreakerDefaultsFillsZeroValue(t *testing.T) {
+	b := &BreakerConfig{}
+	applyBreakerDefaults(b)
+
+	if b.MinRequests < 1 {
+		t.Errorf("MinRequests 应被填充为 >= 1，实际 %d", b.MinRequests)
+	}
+	if b.HalfOpenMaxProbes < 1 {
+		t.Errorf("HalfOpenMaxProbes 应被填充为 >= 1，实际 %d", b.HalfOpenMaxProbes)
+	}
+	if b.FailureRatio <= 0 || b.FailureRatio > 1 {
+		t.Errorf("FailureRatio 应被填充为 (0, 1] 区间内的值，实际 %v", b.FailureRatio)
+	}
+	if b.Window <= 0 {
+		t.Errorf("Window 应被填充为正数，实际 %v", b.Window)
+	}
+	if b.OpenDuration <= 0 {
+		t.Errorf("OpenDuration 应被填充为正数，实际 %v", b.OpenDuration)
+	}
+}
+
+// TestApplyBreakerDefaultsKeepsExplicitValues 验证已显式配置的字段不会被默认值覆盖。
+func TestApplyBreakerDefaultsKeepsExplicitValues(t *testing.T) {
+	b := &BreakerConfig{
+		Window:            time.Minute,
+		MinRequests:       4,
+		FailureRatio:      0.5,
+		OpenDuration:      20 * time.Millisecond,
+		HalfOpenMaxProbes: 2,
+	}
+	want := *b
+
+	applyBreakerDefaults(b)
+
+	if *b != want {
+		t.Errorf("已显式配置的 BreakerConfig 不应被修改: got %+v, want %+v", *b, want)
+	}
+}
+
+// TestApplyBreakerDefaultsNilIsNoop 验证未配置熔断（Breaker 为 nil）时不会 panic
+// 也不会被意外创建出一个非 nil 的 BreakerConfig。
+func TestApplyBreakerDefaultsNilIsNoop(t *testing.T) {
+	applyBreakerDefaults(nil)
+}
+
+// TestLoadConfigAppliesBreakerDefaultsToZeroValueBreaker 端到端验证：YAML 里写
+// `breaker: {}` 经过 loadConfig 之后得到的是一个不会永久 503 的可用配置，
+// 而不是停留在会立即触发 Open 且走不出 Half-Open 的零值状态。
+func TestLoadConfigAppliesBreakerDefaultsToZeroValueBreaker(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/config.yaml"
+	yamlContent := `
+port: ":8082"
+routes:
+  - path: /svc
+    target: http://upstream
+    breaker: {}
+`
+	if err := os.WriteFile(path, []byte(yamlContent), 0o644); err != nil {
+		t.Fatalf("写入测试配置失败: %v", err)
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig 失败: %v", err)
+	}
+
+	b := cfg.Routes[0].Breaker
+	if b == nil {
+		t.Fatal("breaker: {} 不应被解析为 nil")
+	}
+	if b.MinRequests < 1 || b.HalfOpenMaxProbes < 1 || b.FailureRatio <= 0 || b.FailureRatio > 1 || b.Window <= 0 || b.OpenDuration <= 0 {
+		t.Fatalf("loadConfig 应为零值 breaker 填充合理默认值，实际 %+v", b)
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("经过默认值填充后的配置应通过 validateConfig，却返回了错误: %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// claimsCtxKey 是 JWT claims 在请求上下文中的键。
+type claimsCtxKey struct{}
+
+// claimsFrom 取出 AuthMiddleware 校验 JWT 成功后注入的 claims。
+func claimsFrom(r *http.Request) (jwt.MapClaims, bool) {
+	c, ok := r.Context().Value(claimsCtxKey{}).(jwt.MapClaims)
+	return c, ok
+}
+
+// jwkKey 是 JWKS 响应中单个密钥的精简字段，只支持 RS256/ES256 所需部分。
+type jwkKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwkSet struct {
+	Keys []jwkKey `json:"keys"`
+}
+
+// publicKey 把一个 JWKS 密钥条目解析为 *rsa.PublicKey 或 *ecdsa.PublicKey。
+func (k jwkKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("解码 n 失败: %w", err)
+		}
+		eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("解码 e 失败: %w", err)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(nBytes),
+			E: int(new(big.Int).SetBytes(eBytes).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("不支持的曲线: %s", k.Crv)
+		}
+		xBytes, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("解码 x 失败: %w", err)
+		}
+		yBytes, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("解码 y 失败: %w", err)
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(xBytes),
+			Y:     new(big.Int).SetBytes(yBytes),
+		}, nil
+	default:
+		return nil, fmt.Errorf("不支持的密钥类型: %s", k.Kty)
+	}
+}
+
+// jwksCache 按 kid 缓存从 JWKS 端点拉取的公钥，并定期后台刷新；未知 kid 会触发
+// 一次限速的主动重新拉取，应对密钥轮换但缓存尚未到期刷新的情况，同时避免伪造
+// kid 触发对 JWKS 端点的放大请求。
+type jwksCache struct {
+	url    string
+	client *http.Client
+
+	mu   sync.RWMutex
+	keys map[string]interface{}
+
+	refetchMu   sync.Mutex
+	lastRefetch time.Time
+}
+
+// newJWKSCache 创建缓存并启动后台刷新协程。
+func newJWKSCache(cfg JWKSConfig) *jwksCache {
+	interval := cfg.RefreshInterval
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+
+	c := &jwksCache{
+		url:    cfg.URL,
+		client: &http.Client{Timeout: 5 * time.Second},
+		keys:   make(map[string]interface{}),
+	}
+	go c.refreshLoop(interval)
+	return c
+}
+
+func (c *jwksCache) refreshLoop(interval time.Duration) {
+	c.refresh()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.refresh()
+	}
+}
+
+// keyForKid 返回 kid 对应的公钥；未命中缓存时做一次限速（最短间隔 5s）的
+// 主动拉取后再重试一次。
+func (c *jwksCache) keyForKid(kid string) (interface{}, error) {
+	c.mu.RLock()
+	key, ok := c.keys[kid]
+	c.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	c.refetchMu.Lock()
+	tooSoon := time.Since(c.lastRefetch) < 5*time.Second
+	c.refetchMu.Unlock()
+	if tooSoon {
+		return nil, fmt.Errorf("未知 kid %q，且刚刷新过 JWKS，暂不重试", kid)
+	}
+
+	c.refresh()
+
+	c.mu.RLock()
+	key, ok = c.keys[kid]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("JWKS 中找不到 kid %q", kid)
+	}
+	return key, nil
+}
+
+func (c *jwksCache) refresh() {
+	c.refetchMu.Lock()
+	c.lastRefetch = time.Now()
+	c.refetchMu.Unlock()
+
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		log.Printf("[JWKS] 拉取 %s 失败: %v", c.url, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var set jwkSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		log.Printf("[JWKS] 解析 %s 响应失败: %v", c.url, err)
+		return
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		pub, err := k.publicKey()
+		if err != nil {
+			log.Printf("[JWKS] 跳过无法解析的密钥 kid=%s: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+}
+
+// jwtValidator 封装一次 JWT 校验所需的全部依据：HMAC 密钥或 JWKS 缓存、
+// 期望的 iss 与默认 aud（路由可覆盖）。
+//
+// 注意：jwtValidator 持有 JWKS 的后台刷新协程，和 proxy.go 的上游连接池一样
+// 只在启动时根据初始配置快照创建一次，config.yaml 热更新不会重建它（见
+// router.go 中关于上游连接池的同类说明）。
+type jwtValidator struct {
+	secret []byte
+	jwks   *jwksCache
+	issuer string
+	aud    string
+}
+
+// newJWTValidator 根据 JWTConfig 构建校验器；JWKS 非空时会启动后台刷新。
+func newJWTValidator(cfg JWTConfig) *jwtValidator {
+	v := &jwtValidator{issuer: cfg.Issuer, aud: cfg.Audience}
+	if cfg.Secret != "" {
+		v.secret = []byte(cfg.Secret)
+	}
+	if cfg.JWKS != nil && cfg.JWKS.URL != "" {
+		v.jwks = newJWKSCache(*cfg.JWKS)
+	}
+	return v
+}
+
+// validate 解析并校验 token 的签名、exp、nbf、iss、aud，成功时返回其 claims。
+// aud 为空时使用校验器的默认 Audience。
+func (v *jwtValidator) validate(tokenString, aud string) (jwt.MapClaims, error) {
+	if aud == "" {
+		aud = v.aud
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "HS384", "HS512", "RS256", "ES256"}),
+	}
+	if v.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.issuer))
+	}
+	if aud != "" {
+		opts = append(opts, jwt.WithAudience(aud))
+	}
+
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			if v.secret == nil {
+				return nil, fmt.Errorf("未配置 HMAC 密钥")
+			}
+			return v.secret, nil
+		default:
+			if v.jwks == nil {
+				return nil, fmt.Errorf("未配置 JWKS，无法校验非对称签名")
+			}
+			kid, _ := t.Header["kid"].(string)
+			return v.jwks.keyForKid(kid)
+		}
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("无效的 token")
+	}
+	return claims, nil
+}
+
+// claimHeaders 返回配置的 claim -> 下游请求头映射；未配置 JWT 时返回 nil。
+func claimHeaders(cfg *JWTConfig) map[string]string {
+	if cfg == nil {
+		return nil
+	}
+	return cfg.ClaimHeaders
+}
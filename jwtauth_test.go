@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func encodeRSAJWK(kid string, pub *rsa.PublicKey) jwkKey {
+	return jwkKey{
+		Kty: "RSA",
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+func encodeECJWK(kid, crv string, pub *ecdsa.PublicKey) jwkKey {
+	return jwkKey{
+		Kty: "EC",
+		Kid: kid,
+		Crv: crv,
+		X:   base64.RawURLEncoding.EncodeToString(pub.X.Bytes()),
+		Y:   base64.RawURLEncoding.EncodeToString(pub.Y.Bytes()),
+	}
+}
+
+// TestJWKKeyPublicKeyRSA 验证从 JWKS 的 base64url n/e 字段能正确还原出
+// *rsa.PublicKey。
+func TestJWKKeyPublicKeyRSA(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("生成 RSA 密钥失败: %v", err)
+	}
+
+	got, err := encodeRSAJWK("rsa-1", &priv.PublicKey).publicKey()
+	if err != nil {
+		t.Fatalf("publicKey() 返回错误: %v", err)
+	}
+
+	rsaPub, ok := got.(*rsa.PublicKey)
+	if !ok {
+		t.Fatalf("publicKey() 返回类型 %T, want *rsa.PublicKey", got)
+	}
+	if rsaPub.E != priv.PublicKey.E || rsaPub.N.Cmp(priv.PublicKey.N) != 0 {
+		t.Fatal("还原出的 RSA 公钥与原始公钥不一致")
+	}
+}
+
+// TestJWKKeyPublicKeyECDSA 验证从 JWKS 的 base64url x/y 字段能正确还原出
+// *ecdsa.PublicKey。
+func TestJWKKeyPublicKeyECDSA(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成 EC 密钥失败: %v", err)
+	}
+
+	got, err := encodeECJWK("ec-1", "P-256", &priv.PublicKey).publicKey()
+	if err != nil {
+		t.Fatalf("publicKey() 返回错误: %v", err)
+	}
+
+	ecPub, ok := got.(*ecdsa.PublicKey)
+	if !ok {
+		t.Fatalf("publicKey() 返回类型 %T, want *ecdsa.PublicKey", got)
+	}
+	if ecPub.X.Cmp(priv.PublicKey.X) != 0 || ecPub.Y.Cmp(priv.PublicKey.Y) != 0 {
+		t.Fatal("还原出的 EC 公钥与原始公钥不一致")
+	}
+}
+
+// TestJWKKeyPublicKeyUnsupported 验证不支持的 kty/crv 会返回错误而不是 panic。
+func TestJWKKeyPublicKeyUnsupported(t *testing.T) {
+	if _, err := (jwkKey{Kty: "OKP"}).publicKey(); err == nil {
+		t.Fatal("不支持的 kty 应返回错误")
+	}
+	if _, err := (jwkKey{Kty: "EC", Crv: "P-521"}).publicKey(); err == nil {
+		t.Fatal("不支持的 curve 应返回错误")
+	}
+}
+
+// TestJWTValidatorHMAC 验证 HMAC 签名的 token 用正确密钥能通过校验，
+// 错误密钥签名的 token 会被拒绝。
+func TestJWTValidatorHMAC(t *testing.T) {
+	v := newJWTValidator(JWTConfig{Secret: "s3cret"})
+
+	good := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "u1"})
+	signed, err := good.SignedString([]byte("s3cret"))
+	if err != nil {
+		t.Fatalf("签名失败: %v", err)
+	}
+	claims, err := v.validate(signed, "")
+	if err != nil {
+		t.Fatalf("validate() 返回错误: %v", err)
+	}
+	if claims["sub"] != "u1" {
+		t.Fatalf("claims[sub] = %v, want u1", claims["sub"])
+	}
+
+	bad := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "u1"})
+	badSigned, _ := bad.SignedString([]byte("wrong-secret"))
+	if _, err := v.validate(badSigned, ""); err == nil {
+		t.Fatal("错误密钥签名的 token 应被拒绝")
+	}
+}
+
+// TestJWTValidatorRequiresJWKSForAsymmetric 验证未配置 JWKS 时，RS256/ES256
+// token 会被拒绝而不是 panic 或误用 HMAC 密钥。
+func TestJWTValidatorRequiresJWKSForAsymmetric(t *testing.T) {
+	v := newJWTValidator(JWTConfig{Secret: "s3cret"})
+
+	priv, _ := rsa.GenerateKey(rand.Reader, 2048)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "u1"})
+	signed, err := token.SignedString(priv)
+	if err != nil {
+		t.Fatalf("签名失败: %v", err)
+	}
+
+	if _, err := v.validate(signed, ""); err == nil {
+		t.Fatal("未配置 JWKS 时 RS256 token 应被拒绝")
+	}
+}
+
+// TestJWTValidatorIssuerAndAudience 验证 iss/aud 声明会被强制校验。
+func TestJWTValidatorIssuerAndAudience(t *testing.T) {
+	v := newJWTValidator(JWTConfig{Secret: "s3cret", Issuer: "gateway", Audience: "svc-a"})
+
+	sign := func(claims jwt.MapClaims) string {
+		tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("s3cret"))
+		if err != nil {
+			t.Fatalf("签名失败: %v", err)
+		}
+		return tok
+	}
+
+	valid := sign(jwt.MapClaims{"iss": "gateway", "aud": "svc-a"})
+	if _, err := v.validate(valid, ""); err != nil {
+		t.Fatalf("iss/aud 均匹配时应通过校验: %v", err)
+	}
+
+	wrongIssuer := sign(jwt.MapClaims{"iss": "someone-else", "aud": "svc-a"})
+	if _, err := v.validate(wrongIssuer, ""); err == nil {
+		t.Fatal("iss 不匹配应被拒绝")
+	}
+
+	wrongAudience := sign(jwt.MapClaims{"iss": "gateway", "aud": "svc-b"})
+	if _, err := v.validate(wrongAudience, ""); err == nil {
+		t.Fatal("aud 不匹配应被拒绝")
+	}
+
+	// 路由通过 validate 的第二个参数覆盖默认 Audience。
+	overridden := sign(jwt.MapClaims{"iss": "gateway", "aud": "svc-b"})
+	if _, err := v.validate(overridden, "svc-b"); err != nil {
+		t.Fatalf("路由覆盖 aud 后应通过校验: %v", err)
+	}
+}
+
+// TestJWKSCacheRefreshAndKeyForKid 验证 jwksCache.refresh 会从 JWKS 端点拉取
+// 并按 kid 建立索引；这里直接构造 jwksCache、手动调用 refresh，避免
+// newJWKSCache 启动的后台协程带来的异步时序不确定性。
+func TestJWKSCacheRefreshAndKeyForKid(t *testing.T) {
+	priv, _ := rsa.GenerateKey(rand.Reader, 2048)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwkSet{Keys: []jwkKey{encodeRSAJWK("k1", &priv.PublicKey)}})
+	}))
+	defer server.Close()
+
+	cache := &jwksCache{url: server.URL, client: server.Client(), keys: make(map[string]interface{})}
+	cache.refresh()
+
+	key, err := cache.keyForKid("k1")
+	if err != nil {
+		t.Fatalf("keyForKid(k1) 返回错误: %v", err)
+	}
+	if _, ok := key.(*rsa.PublicKey); !ok {
+		t.Fatalf("keyForKid(k1) 返回类型 %T, want *rsa.PublicKey", key)
+	}
+}
+
+// TestJWKSCacheUnknownKidRefetchIsRateLimited 验证未知 kid 会触发一次主动
+// 重新拉取，但短时间内重复的未知 kid 查询不会再次打到 JWKS 端点
+// （避免伪造 kid 触发对 JWKS 端点的放大请求）。
+func TestJWKSCacheUnknownKidRefetchIsRateLimited(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		json.NewEncoder(w).Encode(jwkSet{Keys: nil})
+	}))
+	defer server.Close()
+
+	// 全新缓存，尚未发生过任何拉取：第一次未知 kid 查询应触发一次主动重新拉取。
+	cache := &jwksCache{url: server.URL, client: server.Client(), keys: make(map[string]interface{})}
+
+	if _, err := cache.keyForKid("missing"); err == nil {
+		t.Fatal("未知 kid 应返回错误")
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("未知 kid 应触发一次主动重新拉取，实际命中 %d 次", hits.Load())
+	}
+
+	// 紧接着再查另一个未知 kid：刚刚才刷新过，应命中 5s 限速窗口，不再重新拉取。
+	if _, err := cache.keyForKid("still-missing"); err == nil {
+		t.Fatal("未知 kid 应返回错误")
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("5s 限速窗口内的未知 kid 查询不应再次拉取，实际命中 %d 次", hits.Load())
+	}
+}
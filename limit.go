@@ -2,112 +2,235 @@ package main
 
 import (
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
-// TokenBucket 令牌桶结构体，用于实现限流功能。
-// Cap: 桶的最大令牌容量。
-// Rate: 每秒生成的令牌数量。
-// interval: 令牌生成的时间间隔（默认为1秒）。
-// Tokens: 当前桶中剩余的令牌数。
-// LastCheck: 上一次检查并补充令牌的时间点。
-// mu: 互斥锁，确保并发安全。
-type TokenBucket struct {
-	Cap       int           // 桶最大容量
-	Rate      int           // 生成速率
-	interval  time.Duration // 生成间隔
-	Tokens    int           // 当前令牌数
-	LastCheck time.Time     // 上次生成令牌的时间
-	mu        sync.Mutex    // 保证并发安全
+// limiterIdleTimeout 是限流器在未被访问多久后会被后台清理协程回收
+const limiterIdleTimeout = 10 * time.Minute
+
+// limiterEntry 包装一个 *rate.Limiter 并记录其最近一次被使用的时间，
+// 供清理协程判断该维度（如某个 IP、某个 API Key）是否已经空闲。
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastUsed atomic.Int64 // UnixNano
+}
+
+// limiterRegistry 按 "路由|维度值" 懒创建并缓存 *rate.Limiter，
+// 并周期性清理长时间空闲的条目，避免 sync.Map 无限增长。
+type limiterRegistry struct {
+	limiters sync.Map // key: string -> *limiterEntry
+	sweepOn  sync.Once
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+func newLimiterRegistry() *limiterRegistry {
+	return &limiterRegistry{stopCh: make(chan struct{})}
 }
 
-// NewTokenBucket 创建一个新的令牌桶实例。
-// 参数 cap 表示桶的最大令牌容量。
-// 参数 rate 表示每秒新增的令牌数量。
-// 返回一个初始化完成的 TokenBucket 实例指针。
-func NewTokenBucket(cap int, rate int) *TokenBucket {
-	return &TokenBucket{
-		Cap:       cap,
-		Rate:      rate,
-		interval:  1 * time.Second,
-		Tokens:    cap,
-		LastCheck: time.Now(),
-		mu:        sync.Mutex{},
+// Stop 终止清理协程。配置热更新时，ConfigManager 替换下来的旧
+// rateLimitState 会被 cachedDerived 调用一次 Stop，避免每次热重载都多泄漏
+// 一个协程。可安全重复调用。
+func (r *limiterRegistry) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+	})
+}
+
+// getOrCreate 返回 key 对应的限流器，不存在则按 rateHz/burst 新建一个。
+func (r *limiterRegistry) getOrCreate(key string, rateHz float64, burst int) *rate.Limiter {
+	now := time.Now().UnixNano()
+
+	if v, ok := r.limiters.Load(key); ok {
+		entry := v.(*limiterEntry)
+		entry.lastUsed.Store(now)
+		return entry.limiter
 	}
+
+	entry := &limiterEntry{limiter: rate.NewLimiter(rate.Limit(rateHz), burst)}
+	entry.lastUsed.Store(now)
+	actual, _ := r.limiters.LoadOrStore(key, entry)
+	return actual.(*limiterEntry).limiter
+}
+
+// startSweeper 启动（仅一次）后台协程，按 interval 周期清理空闲超过
+// limiterIdleTimeout 的限流器；收到 Stop() 后协程退出。
+func (r *limiterRegistry) startSweeper(interval time.Duration) {
+	r.sweepOn.Do(func() {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					now := time.Now()
+					r.limiters.Range(func(k, v any) bool {
+						entry := v.(*limiterEntry)
+						last := time.Unix(0, entry.lastUsed.Load())
+						if now.Sub(last) > limiterIdleTimeout {
+							r.limiters.Delete(k)
+						}
+						return true
+					})
+				case <-r.stopCh:
+					return
+				}
+			}
+		}()
+	})
+}
+
+// rateLimitState 是从某次配置快照派生出的限流所需状态。registry 持有各
+// (路由, 维度) 的 *rate.Limiter 并在后台清理空闲条目，globalLimiter 是兜底的
+// 全局限流器，noRateLimitSet 是跳过限流的路径集合，trustedProxies 是按 "ip"
+// 维度限流时允许信任其 X-Forwarded-For 声明的反向代理地址集合。
+type rateLimitState struct {
+	noRateLimitSet map[string]struct{}
+	registry       *limiterRegistry
+	globalLimiter  *rate.Limiter
+	trustedProxies map[string]struct{}
+}
+
+// Stop 停止 registry 的后台清理协程；实现 cachedDerived 在重建派生状态时
+// 用于回收旧值的 interface{ Stop() }。
+func (s rateLimitState) Stop() {
+	s.registry.Stop()
 }
 
-// Allow 判断是否允许通过请求，并扣除一个令牌。
-// 若当前桶中有足够令牌则返回 true 并减少一个令牌；
-// 否则返回 false，表示被限流。
-func (tb *TokenBucket) Allow() bool {
-	tb.mu.Lock()
-	defer tb.mu.Unlock()
-
-	// 根据时间差计算需要补充的令牌数
-	now := time.Now()
-	duration := now.Sub(tb.LastCheck)
-	tokens := int(duration.Seconds() * float64(tb.Rate) / tb.interval.Seconds())
-
-	// 补充令牌，但不超过桶的最大容量
-	if tokens > 0 {
-		tb.Tokens = min(tb.Tokens+tokens, tb.Cap)
-		tb.LastCheck = now
+func buildRateLimitState(config *Config) rateLimitState {
+	noRateLimitSet := make(map[string]struct{}, len(config.NoRateLimitRoutes))
+	for _, path := range config.NoRateLimitRoutes {
+		noRateLimitSet[path] = struct{}{}
 	}
 
-	// 尝试获取一个令牌
-	if tb.Tokens > 0 {
-		tb.Tokens--
-		return true
+	trustedProxies := make(map[string]struct{}, len(config.TrustedProxies))
+	for _, ip := range config.TrustedProxies {
+		trustedProxies[ip] = struct{}{}
 	}
-	return false
-}
 
-// 全局默认限流器：容量为1，速率为1 QPS
-var globalLimiter = NewTokenBucket(1, 1)
+	registry := newLimiterRegistry()
+	registry.startSweeper(time.Minute)
 
-// RateLimitMiddleware 是一个中间件工厂函数，根据路由配置应用不同的限流策略。
-// 参数 routes 包含各个路径对应的 QPS 配置信息。
-// 返回一个包装后的 http.HandlerFunc 处理器。
-func RateLimitMiddleware(rotes []Route) Middleware {
-	// 提前给路由构建好各自的限流器
-	routeLimiters := make(map[string]*TokenBucket)
-	for _, route := range rotes {
-		if route.QPS > 0 {
-			routeLimiters[route.Path] = NewTokenBucket(route.QPS*2, route.QPS)
-			break
-		}
+	return rateLimitState{
+		noRateLimitSet: noRateLimitSet,
+		registry:       registry,
+		globalLimiter:  rate.NewLimiter(rate.Limit(config.GlobalRateLimit.Rate), config.GlobalRateLimit.Cap),
+		trustedProxies: trustedProxies,
 	}
+}
+
+// RateLimitMiddleware 是一个中间件工厂函数，基于 golang.org/x/time/rate
+// 为每个路由按配置的维度（全局/IP/API Key/自定义请求头）做限流。
+// 参数 cm 提供当前生效的配置快照；限流器注册表只在配置热更新后才会重建。
+// 返回一个包装后的 http.HandlerFunc 处理器。
+func RateLimitMiddleware(cm *ConfigManager) Middleware {
+	var cache cachedDerived[rateLimitState]
 
-	// 返回实际的中间件处理逻辑
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			var limiter *TokenBucket
+			config := cm.Current()
+			state := cache.get(config, buildRateLimitState)
 
-			// 查找该请求路径是否有专用的限流器
-			for path, tb := range routeLimiters {
-				if r.URL.Path == path {
-					limiter = tb
-					break
-				}
+			if _, ok := state.noRateLimitSet[r.URL.Path]; ok {
+				next(w, r)
+				return
 			}
 
-			// 如果没有找到专用限流器，则使用全局默认限流器
-			if limiter == nil {
-				limiter = globalLimiter
+			limiter := resolveLimiter(config, state, r)
+
+			reservation := limiter.Reserve()
+			if !reservation.OK() {
+				http.Error(w, "429 Too Many Requests", http.StatusTooManyRequests)
+				rateLimitRejections.WithLabelValues(routeLabel(r)).Inc()
+				log.Printf("[限流] %s %s | 请求无法被满足（突发量超过桶容量）", r.Method, r.URL.Path)
+				return
 			}
 
-			// 执行限流判断
-			if limiter.Allow() {
-				next(w, r)
-			} else {
-				// 触发限流时返回 429 状态码和提示信息
-				w.Header().Set("Retry-After", "1")
+			if delay := reservation.Delay(); delay > 0 {
+				reservation.Cancel()
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
 				http.Error(w, "429 Too Many Requests", http.StatusTooManyRequests)
-				log.Printf("[限流] %s %s | 超过QPS限制", r.Method, r.URL.Path)
+				rateLimitRejections.WithLabelValues(routeLabel(r)).Inc()
+				log.Printf("[限流] %s %s | 超过限流阈值，需等待 %.2fs", r.Method, r.URL.Path, delay.Seconds())
 				return
 			}
+
+			next(w, r)
 		}
 	}
 }
+
+// resolveLimiter 根据 RouterMiddleware 匹配到的路由是否配置了专属限流，
+// 挑选（或懒创建）对应的限流器；未命中路由专属配置时回退到全局默认限流器。
+func resolveLimiter(config *Config, state rateLimitState, r *http.Request) *rate.Limiter {
+	matched, ok := matchedRouteFrom(r)
+	if !ok {
+		return state.globalLimiter
+	}
+	route := matched.Route
+
+	key := rateLimitDimensionKey(config.RateLimitKey, r, state.trustedProxies)
+
+	switch {
+	case route.RateLimit != nil:
+		return state.registry.getOrCreate(route.Path+"|"+key, route.RateLimit.Rate, route.RateLimit.Burst)
+	case route.QPS > 0:
+		// 兼容仅配置了旧版 qps 字段的路由，突发量沿用此前 2 倍 QPS 的经验值
+		return state.registry.getOrCreate(route.Path+"|"+key, float64(route.QPS), route.QPS*2)
+	default:
+		return state.globalLimiter
+	}
+}
+
+// rateLimitDimensionKey 根据 dimension 计算本次请求应归属的限流维度值。
+// dimension 为空或 "global" 时所有请求共用同一维度；"ip"/"apikey" 为内置维度；
+// 其余取值被当作请求头名称使用。trustedProxies 透传给 clientIP，决定 "ip"
+// 维度是否可以信任 X-Forwarded-For。
+func rateLimitDimensionKey(dimension string, r *http.Request, trustedProxies map[string]struct{}) string {
+	switch dimension {
+	case "", "global":
+		return "global"
+	case "ip":
+		return clientIP(r, trustedProxies)
+	case "apikey":
+		return r.Header.Get("X-API-Key")
+	default:
+		return r.Header.Get(dimension)
+	}
+}
+
+// remoteHost 返回 r.RemoteAddr 去掉端口后的纯地址部分，即网关实际观察到的
+// TCP 对端地址；RemoteAddr 不是 "host:port" 形式时原样返回。
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP 提取按 "ip" 维度限流时应使用的客户端地址。默认直接使用 TCP 连接
+// 的真实对端地址（r.RemoteAddr），因为网关本身就是公网入口，X-Forwarded-For
+// 是客户端可以随意伪造的请求头——只信任它会让攻击者每次请求换一个伪造值，
+// 从而绕过按 IP 的限流。只有当本次请求的直连对端地址命中 trustedProxies
+// （网关前面受信任的反向代理/负载均衡器地址）时，才改为信任其声明的
+// X-Forwarded-For 第一跳。trustedProxies 为空集合时等价于完全不信任该请求头。
+func clientIP(r *http.Request, trustedProxies map[string]struct{}) string {
+	host := remoteHost(r)
+	if _, trusted := trustedProxies[host]; !trusted {
+		return host
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	return host
+}
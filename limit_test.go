@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+// stoppableDerived 是一个实现了 interface{ Stop() } 的最小派生值，
+// 用于脱离 limiterRegistry 的真实后台协程单独验证 cachedDerived 的回收逻辑。
+type stoppableDerived struct {
+	stopped *atomic.Bool
+}
+
+func (s stoppableDerived) Stop() {
+	s.stopped.Store(true)
+}
+
+// TestCachedDerivedStopsReplacedValue 回归测试：cachedDerived.get 在配置指针
+// 变化触发重建时，必须对被替换下来的旧值调用一次 Stop（如果它实现了
+// interface{ Stop() }），否则持有后台协程的派生状态（如 limiterRegistry）
+// 会在每次热加载后都多泄漏一个协程。
+func TestCachedDerivedStopsReplacedValue(t *testing.T) {
+	var cache cachedDerived[stoppableDerived]
+
+	var stopped1, stopped2 atomic.Bool
+	cfg1 := &Config{Port: ":1"}
+	cache.get(cfg1, func(*Config) stoppableDerived { return stoppableDerived{stopped: &stopped1} })
+
+	cfg2 := &Config{Port: ":2"}
+	cache.get(cfg2, func(*Config) stoppableDerived { return stoppableDerived{stopped: &stopped2} })
+
+	if !stopped1.Load() {
+		t.Fatal("配置指针变化后，旧的派生值应被 Stop")
+	}
+	if stopped2.Load() {
+		t.Fatal("当前仍在使用的派生值不应被 Stop")
+	}
+
+	// 指针未变化时直接复用缓存，不应重建也不应再次 Stop。
+	cache.get(cfg2, func(*Config) stoppableDerived {
+		t.Fatal("配置指针未变化时不应调用 build")
+		return stoppableDerived{}
+	})
+	if stopped2.Load() {
+		t.Fatal("仍在使用的派生值不应被 Stop")
+	}
+}
+
+// TestLimiterRegistryStopStopsSweeper 验证 Stop 会让 startSweeper 启动的后台
+// 协程退出，且可安全重复调用。
+func TestLimiterRegistryStopStopsSweeper(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	r := newLimiterRegistry()
+	r.startSweeper(time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	r.Stop()
+	r.Stop() // 重复调用不应 panic（close 已关闭的 channel）
+}
+
+// TestRateLimitStateStopStopsRegistrySweeper 回归测试：rateLimitState.Stop
+// 必须真正停掉 buildRateLimitState 启动的 limiterRegistry 清理协程，否则每次
+// 配置热加载都会经由 cachedDerived 多泄漏一个协程。
+func TestRateLimitStateStopStopsRegistrySweeper(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	state := buildRateLimitState(&Config{GlobalRateLimit: GlobalRateLimitConfig{Cap: 1, Rate: 1}})
+	state.Stop()
+}
+
+// TestRateLimitDimensionKey 验证 rateLimitDimensionKey 按 dimension 取值分派：
+// 空值/"global" 统一归到同一维度，"ip" 取客户端 IP，"apikey" 取 X-API-Key 头，
+// 其余取值被当作自定义请求头名直接读取。
+func TestRateLimitDimensionKey(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/x", nil)
+	req.RemoteAddr = "10.0.0.1:5555"
+	req.Header.Set("X-API-Key", "key-1")
+	req.Header.Set("X-Tenant", "tenant-a")
+
+	cases := map[string]string{
+		"":         "global",
+		"global":   "global",
+		"ip":       "10.0.0.1",
+		"apikey":   "key-1",
+		"X-Tenant": "tenant-a",
+	}
+	for dimension, want := range cases {
+		if got := rateLimitDimensionKey(dimension, req, nil); got != want {
+			t.Fatalf("rateLimitDimensionKey(%q) = %q, want %q", dimension, got, want)
+		}
+	}
+}
+
+// TestClientIPIgnoresForwardedForByDefault 回归测试：网关是公网入口，
+// X-Forwarded-For 是客户端可以随意伪造的请求头，在未配置 trustedProxies 时
+// "ip" 维度必须始终使用真实的 TCP 对端地址，否则每次请求换一个伪造值就能
+// 绕过按 IP 限流。
+func TestClientIPIgnoresForwardedForByDefault(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/x", nil)
+	req.RemoteAddr = "203.0.113.9:5555"
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+
+	if got := clientIP(req, nil); got != "203.0.113.9" {
+		t.Fatalf("clientIP = %q, want 真实对端地址 203.0.113.9", got)
+	}
+}
+
+// TestClientIPTrustsForwardedForFromTrustedProxy 验证只有当直连对端地址命中
+// trustedProxies 时，才会信任其声明的 X-Forwarded-For 第一跳。
+func TestClientIPTrustsForwardedForFromTrustedProxy(t *testing.T) {
+	trustedProxies := map[string]struct{}{"203.0.113.9": {}}
+
+	req := httptest.NewRequest(http.MethodGet, "/x", nil)
+	req.RemoteAddr = "203.0.113.9:5555"
+	req.Header.Set("X-Forwarded-For", "198.51.100.2, 203.0.113.9")
+
+	if got := clientIP(req, trustedProxies); got != "198.51.100.2" {
+		t.Fatalf("clientIP = %q, want 受信任代理声明的首跳 198.51.100.2", got)
+	}
+}
+
+// withMatchedRoute 把路由注入请求上下文，模拟 RouterMiddleware 已经执行过。
+func withMatchedRoute(r *http.Request, route *RouteConfig) *http.Request {
+	ctx := context.WithValue(r.Context(), routeCtxKey{}, &matchedRoute{Route: route})
+	return r.WithContext(ctx)
+}
+
+// TestResolveLimiterPrefersRouteRateLimit 验证路由配置了 RateLimit 时，
+// resolveLimiter 按 (路由, 维度) 懒创建专属限流器，而不是退回全局限流器。
+func TestResolveLimiterPrefersRouteRateLimit(t *testing.T) {
+	config := &Config{GlobalRateLimit: GlobalRateLimitConfig{Cap: 100, Rate: 100}}
+	state := buildRateLimitState(config)
+	defer state.Stop()
+
+	route := &RouteConfig{Path: "/orders", RateLimit: &RateLimitConfig{Rate: 1, Burst: 1}}
+	req := withMatchedRoute(httptest.NewRequest(http.MethodGet, "/orders", nil), route)
+
+	got := resolveLimiter(config, state, req)
+	if got == state.globalLimiter {
+		t.Fatal("路由配置了专属 RateLimit 时不应使用全局限流器")
+	}
+
+	again := resolveLimiter(config, state, req)
+	if got != again {
+		t.Fatal("同一路由同一维度应复用同一个限流器实例")
+	}
+}
+
+// TestResolveLimiterFallsBackToGlobal 验证未命中路由或路由未配置限流时，
+// 回退到全局限流器。
+func TestResolveLimiterFallsBackToGlobal(t *testing.T) {
+	config := &Config{GlobalRateLimit: GlobalRateLimitConfig{Cap: 100, Rate: 100}}
+	state := buildRateLimitState(config)
+	defer state.Stop()
+
+	unmatched := httptest.NewRequest(http.MethodGet, "/unmatched", nil)
+	if got := resolveLimiter(config, state, unmatched); got != state.globalLimiter {
+		t.Fatal("未匹配到路由时应使用全局限流器")
+	}
+
+	plainRoute := &RouteConfig{Path: "/plain"}
+	matched := withMatchedRoute(httptest.NewRequest(http.MethodGet, "/plain", nil), plainRoute)
+	if got := resolveLimiter(config, state, matched); got != state.globalLimiter {
+		t.Fatal("路由未配置 RateLimit/QPS 时应使用全局限流器")
+	}
+}
+
+// TestRateLimitMiddlewareRejectsBurstOverflowWithRetryAfter 验证超出令牌桶容量
+// 的突发请求被拒绝为 429，且响应头带上按 Reserve().Delay() 计算的 Retry-After。
+func TestRateLimitMiddlewareRejectsBurstOverflowWithRetryAfter(t *testing.T) {
+	cm := newTestConfigManager(&Config{GlobalRateLimit: GlobalRateLimitConfig{Cap: 1, Rate: 1}})
+	handler := RateLimitMiddleware(cm)(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	first := httptest.NewRecorder()
+	handler(first, httptest.NewRequest(http.MethodGet, "/x", nil))
+	if first.Code != http.StatusOK {
+		t.Fatalf("第一个请求应消耗掉唯一的令牌并通过，状态码 = %d", first.Code)
+	}
+
+	second := httptest.NewRecorder()
+	handler(second, httptest.NewRequest(http.MethodGet, "/x", nil))
+	if second.Code != http.StatusTooManyRequests {
+		t.Fatalf("令牌桶已耗尽时应返回 429，实际 = %d", second.Code)
+	}
+	if second.Header().Get("Retry-After") == "" {
+		t.Fatal("被限流的响应应带上 Retry-After 头")
+	}
+}
+
+// TestRateLimitMiddlewareSkipsConfiguredRoutes 验证 NoRateLimitRoutes 中列出的
+// 路径会完全跳过限流判断。
+func TestRateLimitMiddlewareSkipsConfiguredRoutes(t *testing.T) {
+	cm := newTestConfigManager(&Config{
+		GlobalRateLimit:   GlobalRateLimitConfig{Cap: 0, Rate: 0},
+		NoRateLimitRoutes: []string{"/metrics"},
+	})
+	handler := RateLimitMiddleware(cm)(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("白名单路径不应被限流，第 %d 次请求状态码 = %d", i+1, rec.Code)
+		}
+	}
+}
@@ -3,47 +3,101 @@ package main
 import (
 	"context"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// ResponseWriterWrapper 包装 http.ResponseWriter 以捕获状态码
+// ResponseWriterWrapper 包装 http.ResponseWriter 以捕获状态码与响应体大小
 type ResponseWriterWrapper struct {
 	http.ResponseWriter
-	StatusCode int // 响应的状态码
+	StatusCode    int   // 响应的状态码
+	BytesWritten  int64 // 已写入的响应体字节数
+	headerWritten bool  // 防止 WriteHeader 被重复调用
 }
 
-// WriteHeader 实现 http.ResponseWriter 接口，并记录状态码
+// WriteHeader 实现 http.ResponseWriter 接口，记录状态码，并防止重复调用
 func (w *ResponseWriterWrapper) WriteHeader(statusCode int) {
+	if w.headerWritten {
+		return
+	}
+	w.headerWritten = true
 	w.StatusCode = statusCode
 	w.ResponseWriter.WriteHeader(statusCode)
 }
 
+// Write 实现 http.ResponseWriter 接口，统计写入的响应体字节数
+func (w *ResponseWriterWrapper) Write(b []byte) (int, error) {
+	if !w.headerWritten {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.BytesWritten += int64(n)
+	return n, err
+}
+
+// Unwrap 暴露被包装的真正 http.ResponseWriter，供 http.NewResponseController
+// 沿 Unwrap 链找到底层的 Hijacker/Flusher 实现。没有这个方法时，凡是被本
+// 包装器包过一层的 ResponseWriter 在协议升级（如 WebSocket）请求上都会被
+// httputil.ReverseProxy 拒绝，报 "can't switch protocols using non-Hijacker
+// ResponseWriter type ..."。
+func (w *ResponseWriterWrapper) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // Middleware 是中间件类型定义，用于包装 HTTP 处理函数
 type Middleware func(handlerFunc http.HandlerFunc) http.HandlerFunc
 
 // main 函数是程序入口点，初始化路由、中间件并启动 HTTP 服务器
 func main() {
-	config, err := loadConfig("config.yaml")
+	cm, err := NewConfigManager("config.yaml")
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	config := cm.Current()
+
+	// jwtAuth 和 proxy.go 的上游连接池一样，只在启动时根据初始配置快照创建一次，
+	// 持有 JWKS 的后台刷新协程；config.yaml 热更新不会重建它（见 jwtauth.go 中
+	// jwtValidator 的说明）
+	var jwtAuth *jwtValidator
+	if config.Auth.JWT != nil {
+		jwtAuth = newJWTValidator(*config.Auth.JWT)
+	}
 
-	// 初始化中间件链
-	middlewares := []Middleware{
-		AuthMiddleware(config),
-		RateLimitMiddleware(config),
+	// common 是所有端点共用的中间件，均按 cm 的当前快照读取配置，
+	// 因此 config.yaml 的热更新无需重启即可生效
+	common := []Middleware{
+		MetricsMiddleware(),
+		AuthMiddleware(cm, jwtAuth),
+		RateLimitMiddleware(cm),
 		CORSAMiddleware(),
-		TimeoutMiddleware(3 * time.Second),
+		TimeoutMiddleware(cm),
 		LogMiddleware(),
 	}
 
-	// 构建处理函数并注册到根路径
-	handler := ChainMiddleware(proxyHandler(config.Routes), middlewares...)
+	// 代理链额外在最前面插入 RouterMiddleware 完成一次路由匹配，
+	// 并把匹配到的路由模板注入上下文供 common 中间件与熔断器使用；
+	// 未匹配到路由时直接 404，不会进入 common 链
+	proxyMiddlewares := append([]Middleware{RouterMiddleware(cm)}, common...)
+	proxyMiddlewares = append(proxyMiddlewares, CircuitBreakerMiddleware(cm))
+
+	// 构建处理函数并注册到根路径；上游连接池基于启动时的配置快照创建，
+	// 新增/删除路由需要重启才能反映到代理层（见 RouterMiddleware 的说明）
+	proxy, pools := newProxyHandler(config.Routes, claimHeaders(config.Auth.JWT))
+	handler := ChainMiddleware(proxy, proxyMiddlewares...)
 	http.HandleFunc("/", handler)
 
+	// /metrics、/admin/pools 都不经过路由匹配（二者本身都不是业务路由），但仍要
+	// 经过 common 中的 AuthMiddleware：/metrics 依赖 NoAuthRoutes/NoRateLimitRoutes
+	// 跳过认证与限流（默认应包含 "/metrics"），/admin/pools 会暴露上游目标地址、
+	// 健康状态与在途请求数，必须和业务路由一样要求合法 API Key/JWT，不能裸露。
+	http.HandleFunc("/metrics", ChainMiddleware(promhttp.Handler().ServeHTTP, common...))
+	http.HandleFunc("/admin/pools", ChainMiddleware(adminPoolsHandler(pools), common...))
+
 	fmt.Printf("服务已启动：[%s]\n", config.Port)
 
 	// 启动 HTTP 服务器监听在端口 8082 上
@@ -54,44 +108,134 @@ func main() {
 	}
 }
 
-func AuthMiddleware(config *Config) Middleware {
-	apiKeysSet := make(map[string]struct{})
+// authSets 是从配置派生出的、AuthMiddleware 每次请求都要用到的查找表。
+type authSets struct {
+	apiKeys map[string]struct{}
+	noAuth  map[string]struct{}
+}
+
+func buildAuthSets(config *Config) authSets {
+	apiKeysSet := make(map[string]struct{}, len(config.ApiKeys))
 	for _, v := range config.ApiKeys {
 		apiKeysSet[v] = struct{}{}
 	}
 
-	noAuthSet := make(map[string]struct{})
+	noAuthSet := make(map[string]struct{}, len(config.NoAuthRoutes))
 	for _, v := range config.NoAuthRoutes {
 		noAuthSet[v] = struct{}{}
 	}
 
+	return authSets{apiKeys: apiKeysSet, noAuth: noAuthSet}
+}
+
+// AuthMiddleware 基于 ConfigManager 的当前快照做认证；每条路由可通过
+// RouteConfig.AuthScheme 选择 none/apikey/jwt/any，留空沿用原有的 apikey 行为。
+// apiKeysSet/noAuthSet 只在配置发生热更新后才会重新构建；jwtValidator 见其自身说明。
+func AuthMiddleware(cm *ConfigManager, jwtAuth *jwtValidator) Middleware {
+	var cache cachedDerived[authSets]
+
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			if _, ok := noAuthSet[r.URL.Path]; ok {
+			config := cm.Current()
+			sets := cache.get(config, buildAuthSets)
+
+			if _, ok := sets.noAuth[r.URL.Path]; ok {
 				next(w, r)
 				return
 			}
 
-			apiKey := r.Header.Get("X-API-Key")
-			if apiKey == "" {
-				w.Header().Set("WWW-Authenticate", "X-API-Key") // 提示客户端需要携带API Key
-				http.Error(w, "401 Unauthorized: Missing X-API-Key", http.StatusUnauthorized)
-				log.Printf("[认证失败] %s %s | 未携带API Key", r.Method, r.URL.Path)
-				return
+			scheme := "apikey"
+			if matched, ok := matchedRouteFrom(r); ok && matched.Route.AuthScheme != "" {
+				scheme = matched.Route.AuthScheme
 			}
 
-			if _, ok := apiKeysSet[apiKey]; ok {
-				log.Printf("[认证成功] %s %s | API Key: %s", r.Method, r.URL.Path, apiKey)
+			switch scheme {
+			case "none":
 				next(w, r)
-			} else {
-				http.Error(w, "401 Unauthorized: Invalid X-API-Key", http.StatusUnauthorized)
-				log.Printf("[认证失败] %s %s | 非法API Key: %s", r.Method, r.URL.Path, apiKey)
-				return
+			case "jwt":
+				claims, ok := authenticateJWT(jwtAuth, r)
+				if !ok {
+					rejectUnauthorized(w, r, "缺少或非法的 Bearer token")
+					return
+				}
+				next(w, r.WithContext(context.WithValue(r.Context(), claimsCtxKey{}, claims)))
+			case "any":
+				if claims, ok := authenticateJWT(jwtAuth, r); ok {
+					next(w, r.WithContext(context.WithValue(r.Context(), claimsCtxKey{}, claims)))
+					return
+				}
+				if authenticateAPIKey(sets, r) {
+					next(w, r)
+					return
+				}
+				rejectUnauthorized(w, r, "缺少合法的 API Key 或 Bearer token")
+			default: // apikey
+				apiKey := r.Header.Get("X-API-Key")
+				if apiKey == "" {
+					w.Header().Set("WWW-Authenticate", "X-API-Key") // 提示客户端需要携带API Key
+					http.Error(w, "401 Unauthorized: Missing X-API-Key", http.StatusUnauthorized)
+					authFailures.WithLabelValues(routeLabel(r), "missing_key").Inc()
+					log.Printf("[认证失败] %s %s | 未携带API Key", r.Method, r.URL.Path)
+					return
+				}
+
+				if _, ok := sets.apiKeys[apiKey]; ok {
+					log.Printf("[认证成功] %s %s | API Key: %s", r.Method, r.URL.Path, apiKey)
+					next(w, r)
+				} else {
+					http.Error(w, "401 Unauthorized: Invalid X-API-Key", http.StatusUnauthorized)
+					authFailures.WithLabelValues(routeLabel(r), "invalid_key").Inc()
+					log.Printf("[认证失败] %s %s | 非法API Key: %s", r.Method, r.URL.Path, apiKey)
+				}
 			}
 		}
 	}
 }
 
+// authenticateAPIKey 仅做布尔校验，供 authScheme=any 时与 JWT 做或校验，不记录日志。
+func authenticateAPIKey(sets authSets, r *http.Request) bool {
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		return false
+	}
+	_, ok := sets.apiKeys[apiKey]
+	return ok
+}
+
+// authenticateJWT 从 Authorization: Bearer 头解析并校验 JWT，成功时返回其 claims；
+// 该路由配置了 Audience 时覆盖 jwtAuth 的默认 aud。
+func authenticateJWT(jwtAuth *jwtValidator, r *http.Request) (jwt.MapClaims, bool) {
+	if jwtAuth == nil {
+		return nil, false
+	}
+
+	header := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if !strings.HasPrefix(header, prefix) {
+		return nil, false
+	}
+
+	aud := ""
+	if matched, ok := matchedRouteFrom(r); ok {
+		aud = matched.Route.Audience
+	}
+
+	claims, err := jwtAuth.validate(strings.TrimPrefix(header, prefix), aud)
+	if err != nil {
+		authFailures.WithLabelValues(routeLabel(r), "invalid_jwt").Inc()
+		log.Printf("[认证失败] %s %s | JWT 校验失败: %v", r.Method, r.URL.Path, err)
+		return nil, false
+	}
+	return claims, true
+}
+
+// rejectUnauthorized 统一返回 401 并记录认证失败指标与日志。
+func rejectUnauthorized(w http.ResponseWriter, r *http.Request, reason string) {
+	http.Error(w, "401 Unauthorized: "+reason, http.StatusUnauthorized)
+	authFailures.WithLabelValues(routeLabel(r), "unauthorized").Inc()
+	log.Printf("[认证失败] %s %s | %s", r.Method, r.URL.Path, reason)
+}
+
 // LogMiddleware 返回一个日志记录中间件，用于记录请求方法、路径、响应状态码及耗时
 //
 // 参数:
@@ -111,50 +255,11 @@ func LogMiddleware() Middleware {
 				ResponseWriter: w,
 			}
 
-			next(w, r)
+			next(wrappedWriter, r)
 
 			// 计算请求耗时（毫秒）
 			cost := time.Since(start).Seconds() * 1000
-			log.Printf("[%s] %s | 状态码：%d | 耗时：%.2fs", method, path, wrappedWriter.StatusCode, cost)
-		}
-	}
-}
-
-// TimeoutMiddleware 返回一个超时控制中间件，在指定时间内未完成请求则返回超时错误
-//
-// 参数:
-//   - timeout: 超时持续时间
-//
-// 返回值:
-//   - Middleware: 包含超时控制逻辑的中间件
-func TimeoutMiddleware(timeout time.Duration) Middleware {
-	return func(next http.HandlerFunc) http.HandlerFunc {
-		return func(w http.ResponseWriter, r *http.Request) {
-			// 创建带超时的上下文
-			ctx, cancel := context.WithTimeout(context.Background(), timeout)
-			defer cancel()
-
-			// 将新上下文附加到请求中
-			r = r.WithContext(ctx)
-
-			// 创建通道用于通知请求是否已完成
-			done := make(chan struct{})
-
-			// 在 goroutine 中执行下一个处理器
-			go func() {
-				next(w, r)
-				close(done)
-			}()
-
-			// 等待请求完成或超时
-			select {
-			case <-done:
-				// 正常结束
-			case <-ctx.Done():
-				// 超时处理
-				http.Error(w, "Request timeout", http.StatusGatewayTimeout)
-				log.Printf("[超时] %s %s | 超时时间：%.2f", r.Method, r.URL.Path, timeout.Seconds()*1000)
-			}
+			log.Printf("[%s] %s (route=%s) | 状态码：%d | 耗时：%.2fs", method, path, routeLabel(r), wrappedWriter.StatusCode, cost)
 		}
 	}
 }
@@ -203,63 +308,3 @@ func ChainMiddleware(handler http.HandlerFunc, middlewares ...Middleware) http.H
 	return handler
 }
 
-// proxyHandler 根据请求路径将请求代理转发至对应的目标地址
-//
-// 参数:
-//   - routes: 路由规则数组，包含源路径与目标地址的映射
-//
-// 返回值:
-//   - http.HandlerFunc: 反向代理处理函数
-func proxyHandler(routes []RouteConfig) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var target string
-		// 查找匹配的路由规则
-		for _, route := range routes {
-			if route.Path == r.URL.Path {
-				target = route.Target
-			}
-		}
-
-		// 若没有找到对应的路由规则，则返回 404 错误
-		if target == "" {
-			http.Error(w, "404 Route Not Found", http.StatusNotFound)
-			return
-		}
-
-		// 构造新的请求对象，携带原始请求的方法、URL 和 Body
-		req, err := http.NewRequestWithContext(r.Context(), r.Method, target, r.Body)
-		if err != nil {
-			http.Error(w, "Failed to create proxy request", http.StatusInternalServerError)
-			return
-		}
-
-		// 复制原请求的所有 Header 到新请求中
-		for k, v := range r.Header {
-			req.Header[k] = v
-		}
-
-		// 发起请求并将结果回传给客户端
-		client := http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			http.Error(w, "Failed to forward request", http.StatusBadGateway)
-			return
-		}
-		defer resp.Body.Close()
-
-		// 回写响应头信息
-		for k, v := range resp.Header {
-			w.Header()[k] = v
-		}
-
-		// 写入响应状态码
-		w.WriteHeader(resp.StatusCode)
-
-		// 将远程响应体复制到当前响应流中
-		_, err = io.Copy(w, resp.Body)
-		if err != nil {
-			http.Error(w, "Failed to copy response body", http.StatusBadGateway)
-			return
-		}
-	}
-}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestMetricsMiddlewareRecordsSuccess 验证正常请求会按 route/method/status
+// 记录到 requestsTotal/requestDurationSeconds/responseSizeBytes，且请求结束后
+// requestsInFlight 归零。
+func TestMetricsMiddlewareRecordsSuccess(t *testing.T) {
+	requestsTotal.Reset()
+	requestDurationSeconds.Reset()
+	responseSizeBytes.Reset()
+	requestsInFlight.Reset()
+
+	handler := MetricsMiddleware()(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if got := testutil.ToFloat64(requestsTotal.WithLabelValues("/users", http.MethodGet, "200")); got != 1 {
+		t.Fatalf("requestsTotal = %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(requestDurationSeconds); got != 1 {
+		t.Fatalf("requestDurationSeconds 观测次数 = %d, want 1", got)
+	}
+	if got := testutil.CollectAndCount(responseSizeBytes); got != 1 {
+		t.Fatalf("responseSizeBytes 观测次数 = %d, want 1", got)
+	}
+	if got := testutil.ToFloat64(requestsInFlight.WithLabelValues("/users")); got != 0 {
+		t.Fatalf("请求结束后 requestsInFlight 应归零，实际 %v", got)
+	}
+}
+
+// TestMetricsMiddlewareRecordsErrorStatus 验证下游返回 5xx 时，状态码标签
+// 按实际状态码（而非固定的 200）记录，供告警按错误率切片。
+func TestMetricsMiddlewareRecordsErrorStatus(t *testing.T) {
+	requestsTotal.Reset()
+
+	handler := MetricsMiddleware()(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if got := testutil.ToFloat64(requestsTotal.WithLabelValues("/orders", http.MethodPost, "500")); got != 1 {
+		t.Fatalf("requestsTotal = %v, want 1", got)
+	}
+}
+
+// TestMetricsMiddlewareInFlightDuringRequest 验证 requestsInFlight 在下游
+// 处理函数运行期间确实被置为 1，而不是只在请求结束后才被观察到非零值。
+func TestMetricsMiddlewareInFlightDuringRequest(t *testing.T) {
+	requestsInFlight.Reset()
+
+	observed := make(chan float64, 1)
+	handler := MetricsMiddleware()(func(w http.ResponseWriter, r *http.Request) {
+		observed <- testutil.ToFloat64(requestsInFlight.WithLabelValues("/slow"))
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/slow", nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if got := <-observed; got != 1 {
+		t.Fatalf("下游处理期间 requestsInFlight 应为 1，实际 %v", got)
+	}
+}
+
+// TestMetricsMiddlewareCountsRateLimitRejections 回归测试：MetricsMiddleware
+// 必须包裹在 RateLimitMiddleware 外层，否则限流拒绝的 429 响应永远走不到
+// MetricsMiddleware，requestsTotal 会漏计真实流量中的一大类响应。
+func TestMetricsMiddlewareCountsRateLimitRejections(t *testing.T) {
+	requestsTotal.Reset()
+
+	cm := newTestConfigManager(&Config{GlobalRateLimit: GlobalRateLimitConfig{Cap: 0, Rate: 0}})
+	handler := MetricsMiddleware()(RateLimitMiddleware(cm)(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("限流应在到达下游处理函数之前就已拒绝请求")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("状态码 = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if got := testutil.ToFloat64(requestsTotal.WithLabelValues("/orders", http.MethodGet, "429")); got != 1 {
+		t.Fatalf("requestsTotal(429) = %v, want 1 —— 限流拒绝未被 MetricsMiddleware 计入", got)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// upstreamCtxKey 用于在请求上下文中传递本次选中的上游及请求起始时间，
+// 便于 ModifyResponse/ErrorHandler 回写 in-flight 计数与上游耗时指标。
+type upstreamCtxKey struct{}
+
+// upstreamCall 记录一次代理调用选中的上游及发起时间。
+type upstreamCall struct {
+	up    *upstream
+	route string
+	start time.Time
+}
+
+// upstream 代表一个可被代理到的后端地址及其运行时状态。
+type upstream struct {
+	url      *url.URL
+	healthy  atomic.Bool
+	inFlight atomic.Int64
+	okStreak atomic.Int32
+}
+
+// upstreamPool 是某条路由的上游集合，负责负载均衡选择与健康检查。
+type upstreamPool struct {
+	route     RouteConfig
+	upstreams []*upstream
+	rrCounter atomic.Uint64
+}
+
+// newUpstreamPool 根据路由配置解析所有上游地址，并在配置了 HealthCheck 时
+// 启动后台健康检查协程。
+func newUpstreamPool(route RouteConfig) (*upstreamPool, error) {
+	if len(route.Target) == 0 {
+		return nil, fmt.Errorf("路由 %s 未配置 target", route.Path)
+	}
+
+	pool := &upstreamPool{route: route}
+	for _, target := range route.Target {
+		parsed, err := url.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("解析路由 %s 的目标地址 %s 失败: %w", route.Path, target, err)
+		}
+		up := &upstream{url: parsed}
+		up.healthy.Store(true)
+		pool.upstreams = append(pool.upstreams, up)
+	}
+
+	if route.HealthCheck != nil {
+		pool.startHealthChecks()
+	}
+
+	return pool, nil
+}
+
+// healthyUpstreams 返回当前标记为健康的上游；若全部不健康则退化为返回全量，
+// 避免健康检查误判导致整条路由彻底不可用。
+func (p *upstreamPool) healthyUpstreams() []*upstream {
+	candidates := make([]*upstream, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		if u.healthy.Load() {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 {
+		return p.upstreams
+	}
+	return candidates
+}
+
+// next 按路由配置的 LoadBalance 策略挑选一个上游。
+func (p *upstreamPool) next(r *http.Request) *upstream {
+	candidates := p.healthyUpstreams()
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	switch p.route.LoadBalance {
+	case "random":
+		return candidates[rand.Intn(len(candidates))]
+	case "least_conn":
+		best := candidates[0]
+		for _, u := range candidates[1:] {
+			if u.inFlight.Load() < best.inFlight.Load() {
+				best = u
+			}
+		}
+		return best
+	case "ip_hash":
+		// 按真实 TCP 对端地址（而非可被伪造的 X-Forwarded-For）做稳定哈希，
+		// 否则客户端可以靠伪造该请求头自行选择由哪个上游处理请求。
+		h := fnv.New32a()
+		h.Write([]byte(remoteHost(r)))
+		return candidates[int(h.Sum32()%uint32(len(candidates)))]
+	default: // round_robin
+		idx := p.rrCounter.Add(1)
+		return candidates[int(idx)%len(candidates)]
+	}
+}
+
+// startHealthChecks 为每个上游启动一个后台协程，按配置的 Interval 探测
+// HealthCheck.Path，连续 HealthyThreshold 次成功后恢复为健康，一次失败即标记为不健康。
+func (p *upstreamPool) startHealthChecks() {
+	hc := p.route.HealthCheck
+
+	interval := hc.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	threshold := hc.HealthyThreshold
+	if threshold <= 0 {
+		threshold = 2
+	}
+	path := hc.Path
+	if path == "" {
+		path = "/healthz"
+	}
+
+	client := &http.Client{Timeout: 2 * time.Second}
+
+	for _, u := range p.upstreams {
+		u := u
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				checkURL := *u.url
+				checkURL.Path = path
+				resp, err := client.Get(checkURL.String())
+				ok := err == nil && resp.StatusCode < http.StatusInternalServerError
+				if resp != nil {
+					resp.Body.Close()
+				}
+
+				if ok {
+					if streak := u.okStreak.Add(1); streak >= int32(threshold) && !u.healthy.Load() {
+						u.healthy.Store(true)
+						log.Printf("[健康检查] 上游 %s 恢复健康", u.url)
+					}
+				} else {
+					u.okStreak.Store(0)
+					if u.healthy.Load() {
+						log.Printf("[健康检查] 上游 %s 被标记为不健康: %v", u.url, err)
+					}
+					u.healthy.Store(false)
+				}
+			}
+		}()
+	}
+}
+
+// newProxyHandler 为每条路由构建一个基于 httputil.ReverseProxy 的反向代理，
+// 支持多上游负载均衡、流式/WebSocket 升级与正确的逐跳头处理，
+// 并返回各路由上游池以便 admin 接口查询状态。
+//
+// 参数:
+//   - routes: 路由规则数组
+//   - claimHeaders: JWT claim 名到下游请求头的映射（AuthMiddleware 校验 JWT 成功后
+//     claims 会被放入请求上下文，这里按映射转发给上游）
+//
+// 返回值:
+//   - http.HandlerFunc: 反向代理处理函数
+//   - map[string]*upstreamPool: 按路径索引的上游池，用于 /admin/pools
+func newProxyHandler(routes []RouteConfig, claimHeaders map[string]string) (http.HandlerFunc, map[string]*upstreamPool) {
+	pools := make(map[string]*upstreamPool)
+	proxies := make(map[string]*httputil.ReverseProxy)
+
+	for _, route := range routes {
+		pool, err := newUpstreamPool(route)
+		if err != nil {
+			log.Printf("[代理初始化] 路由 %s 配置错误，已跳过: %v", route.Path, err)
+			continue
+		}
+		pools[route.Path] = pool
+		proxies[route.Path] = newReverseProxy(pool, claimHeaders)
+	}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		matched, ok := matchedRouteFrom(r)
+		if !ok {
+			http.Error(w, "404 Route Not Found", http.StatusNotFound)
+			return
+		}
+
+		rp, ok := proxies[matched.Route.Path]
+		if !ok {
+			http.Error(w, "404 Route Not Found", http.StatusNotFound)
+			return
+		}
+		rp.ServeHTTP(w, r)
+	}
+
+	return handler, pools
+}
+
+// renderTargetPath 把目标路径模板中的 {name} 占位符替换为路由匹配时捕获的
+// 同名参数值，例如模板 /svc/{id} 配合路由 /users/:id 会被渲染为 /svc/42。
+// 未捕获到任何参数或模板不含占位符时原样返回。
+func renderTargetPath(pathTemplate string, req *http.Request) string {
+	matched, ok := matchedRouteFrom(req)
+	if !ok || len(matched.Params) == 0 {
+		return pathTemplate
+	}
+
+	rendered := pathTemplate
+	for name, value := range matched.Params {
+		rendered = strings.ReplaceAll(rendered, "{"+name+"}", value)
+	}
+	return rendered
+}
+
+// newReverseProxy 构建针对单条路由上游池的 *httputil.ReverseProxy，
+// Director 负责挑选上游、重写请求、补齐 X-Forwarded-* 头并按 claimHeaders
+// 转发 JWT claims，ModifyResponse/ErrorHandler 负责回写 in-flight 计数。
+func newReverseProxy(pool *upstreamPool, claimHeaders map[string]string) *httputil.ReverseProxy {
+	director := func(req *http.Request) {
+		up := pool.next(req)
+		up.inFlight.Add(1)
+		call := &upstreamCall{up: up, route: pool.route.Path, start: time.Now()}
+		*req = *req.WithContext(context.WithValue(req.Context(), upstreamCtxKey{}, call))
+
+		originalHost := req.Host
+		// 附加网关自己观察到的真实对端地址，而不是 clientIP(req)（它可能取自
+		// 客户端自己发来的 X-Forwarded-For）——否则这一跳只是把客户端伪造的值
+		// 原样回显，而不是记录网关自身的连接视角。
+		if fwd := req.Header.Get("X-Forwarded-For"); fwd != "" {
+			req.Header.Set("X-Forwarded-For", fwd+", "+remoteHost(req))
+		} else {
+			req.Header.Set("X-Forwarded-For", remoteHost(req))
+		}
+		proto := "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+		req.Header.Set("X-Forwarded-Proto", proto)
+		req.Header.Set("X-Forwarded-Host", originalHost)
+
+		if claims, ok := claimsFrom(req); ok {
+			for claim, header := range claimHeaders {
+				if v, ok := claims[claim]; ok {
+					req.Header.Set(header, fmt.Sprintf("%v", v))
+				}
+			}
+		}
+
+		req.URL.Scheme = up.url.Scheme
+		req.URL.Host = up.url.Host
+		req.URL.Path = renderTargetPath(up.url.Path, req)
+		if up.url.RawQuery != "" {
+			req.URL.RawQuery = up.url.RawQuery
+		}
+		req.Host = up.url.Host
+	}
+
+	// releaseUpstream 用于没有响应体可言的失败路径（ErrorHandler），
+	// 请求已经彻底结束，可以立即回写 in-flight 计数与耗时。
+	releaseUpstream := func(req *http.Request) {
+		if call, ok := req.Context().Value(upstreamCtxKey{}).(*upstreamCall); ok {
+			releaseCall(call)
+		}
+	}
+
+	return &httputil.ReverseProxy{
+		Director: director,
+		// ModifyResponse 在收到响应头时就会触发，此时响应体可能还在向客户端
+		// 流式拷贝；若在这里直接回写 in-flight 计数，least_conn 会把一个仍在
+		// 传输大/慢响应体的上游当成空闲的，继续往它身上压新请求。因此这里只
+		// 包装 resp.Body，把回写推迟到 Close（httputil.ReverseProxy 在拷贝
+		// 完成或出错后总会调用 Close）。
+		ModifyResponse: func(resp *http.Response) error {
+			if call, ok := resp.Request.Context().Value(upstreamCtxKey{}).(*upstreamCall); ok {
+				resp.Body = releaseOnCloseBody(resp.Body, call)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			releaseUpstream(req)
+			log.Printf("[代理失败] %s %s | %v", req.Method, req.URL.Path, err)
+			http.Error(w, "502 Bad Gateway", http.StatusBadGateway)
+		},
+	}
+}
+
+// releaseCall 回写一次上游调用的 in-flight 计数与耗时指标。
+func releaseCall(call *upstreamCall) {
+	call.up.inFlight.Add(-1)
+	upstreamLatency.WithLabelValues(call.route).Observe(time.Since(call.start).Seconds())
+}
+
+// bodyReleaser 包装 http.Response.Body，在其第一次被 Close 时（而非响应头
+// 刚收到时）才回写 in-flight 计数，使 least_conn 在流式响应体传输期间仍把
+// 该上游视为忙碌。
+//
+// Write 是专门为 101 Switching Protocols（WebSocket 等）准备的：
+// httputil.ReverseProxy.handleUpgradeResponse 要求 res.Body 能被断言为
+// io.ReadWriteCloser 才会走 Hijack 双向转发，这里的包装必须原样透出底层
+// body 的可写性，否则所有协议升级请求都会在断言处失败。普通响应体不可写，
+// Write 也就永远不会被调用。
+type bodyReleaser struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func releaseOnCloseBody(body io.ReadCloser, call *upstreamCall) io.ReadCloser {
+	return &bodyReleaser{ReadCloser: body, release: func() { releaseCall(call) }}
+}
+
+// Write 将写入转发给底层 body（101 响应的 body 同时是到上游的连接），
+// 底层不支持写入时返回错误。
+func (b *bodyReleaser) Write(p []byte) (int, error) {
+	w, ok := b.ReadCloser.(io.Writer)
+	if !ok {
+		return 0, fmt.Errorf("底层响应体 %T 不支持写入", b.ReadCloser)
+	}
+	return w.Write(p)
+}
+
+func (b *bodyReleaser) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// upstreamStatus 是 /admin/pools 返回的单个上游状态快照。
+type upstreamStatus struct {
+	Target   string `json:"target"`
+	Healthy  bool   `json:"healthy"`
+	InFlight int64  `json:"inFlight"`
+}
+
+// adminPoolsHandler 返回一个只读的管理端点，用于查看各路由上游池的健康与负载状态。
+func adminPoolsHandler(pools map[string]*upstreamPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot := make(map[string][]upstreamStatus, len(pools))
+		for path, pool := range pools {
+			statuses := make([]upstreamStatus, 0, len(pool.upstreams))
+			for _, u := range pool.upstreams {
+				statuses = append(statuses, upstreamStatus{
+					Target:   u.url.String(),
+					Healthy:  u.healthy.Load(),
+					InFlight: u.inFlight.Load(),
+				})
+			}
+			snapshot[path] = statuses
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+			log.Printf("[admin] 编码 /admin/pools 响应失败: %v", err)
+		}
+	}
+}
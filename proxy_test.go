@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestPool(strategy string, n int) *upstreamPool {
+	pool := &upstreamPool{route: RouteConfig{Path: "/svc", LoadBalance: strategy}}
+	for i := 0; i < n; i++ {
+		up := &upstream{url: &url.URL{Scheme: "http", Host: "backend"}}
+		up.healthy.Store(true)
+		pool.upstreams = append(pool.upstreams, up)
+	}
+	return pool
+}
+
+// TestUpstreamPoolNextRoundRobin 验证 round_robin（默认策略）按顺序轮询全部上游。
+func TestUpstreamPoolNextRoundRobin(t *testing.T) {
+	pool := newTestPool("round_robin", 3)
+	req := httptest.NewRequest(http.MethodGet, "/svc", nil)
+
+	seen := make(map[*upstream]int)
+	for i := 0; i < 6; i++ {
+		seen[pool.next(req)]++
+	}
+	for _, up := range pool.upstreams {
+		if seen[up] != 2 {
+			t.Fatalf("round_robin 应让每个上游均匀分到 2 次请求，实际 %d", seen[up])
+		}
+	}
+}
+
+// TestUpstreamPoolNextLeastConn 验证 least_conn 总是挑选当前 inFlight 最小的上游。
+func TestUpstreamPoolNextLeastConn(t *testing.T) {
+	pool := newTestPool("least_conn", 3)
+	pool.upstreams[0].inFlight.Store(5)
+	pool.upstreams[1].inFlight.Store(1)
+	pool.upstreams[2].inFlight.Store(3)
+
+	req := httptest.NewRequest(http.MethodGet, "/svc", nil)
+	if got := pool.next(req); got != pool.upstreams[1] {
+		t.Fatalf("least_conn 应选中 inFlight 最小的上游，实际选中 %v", got.url)
+	}
+}
+
+// TestUpstreamPoolNextIPHash 验证 ip_hash 对同一客户端 IP 总是稳定地选中同一个上游。
+func TestUpstreamPoolNextIPHash(t *testing.T) {
+	pool := newTestPool("ip_hash", 4)
+
+	req := httptest.NewRequest(http.MethodGet, "/svc", nil)
+	req.RemoteAddr = "203.0.113.7:5555"
+
+	first := pool.next(req)
+	for i := 0; i < 5; i++ {
+		if got := pool.next(req); got != first {
+			t.Fatal("ip_hash 对同一客户端 IP 应稳定选中同一个上游")
+		}
+	}
+}
+
+// TestUpstreamPoolNextIPHashIgnoresForwardedFor 回归测试：ip_hash 必须按真实
+// TCP 对端地址（RemoteAddr）稳定哈希，伪造的 X-Forwarded-For 不应影响选中的
+// 上游，否则客户端可以靠伪造该请求头自行挑选由哪个上游实例处理请求。
+func TestUpstreamPoolNextIPHashIgnoresForwardedFor(t *testing.T) {
+	pool := newTestPool("ip_hash", 4)
+
+	req := httptest.NewRequest(http.MethodGet, "/svc", nil)
+	req.RemoteAddr = "203.0.113.7:5555"
+	want := pool.next(req)
+
+	for i := 0; i < 5; i++ {
+		spoofed := httptest.NewRequest(http.MethodGet, "/svc", nil)
+		spoofed.RemoteAddr = "203.0.113.7:5555"
+		spoofed.Header.Set("X-Forwarded-For", fmt.Sprintf("10.0.0.%d", i))
+		if got := pool.next(spoofed); got != want {
+			t.Fatal("伪造 X-Forwarded-For 不应改变 ip_hash 选中的上游")
+		}
+	}
+}
+
+// TestUpstreamPoolNextRandom 验证 random 策略只会从候选上游集合中选择，不会越界。
+func TestUpstreamPoolNextRandom(t *testing.T) {
+	pool := newTestPool("random", 3)
+	req := httptest.NewRequest(http.MethodGet, "/svc", nil)
+
+	valid := make(map[*upstream]bool)
+	for _, up := range pool.upstreams {
+		valid[up] = true
+	}
+	for i := 0; i < 20; i++ {
+		if got := pool.next(req); !valid[got] {
+			t.Fatalf("random 选中了不在候选集合中的上游 %v", got.url)
+		}
+	}
+}
+
+// TestHealthyUpstreamsFallsBackToAllWhenAllUnhealthy 验证全部上游都被标记为
+// 不健康时，healthyUpstreams 退化为返回全量上游，避免整条路由彻底不可用。
+func TestHealthyUpstreamsFallsBackToAllWhenAllUnhealthy(t *testing.T) {
+	pool := newTestPool("round_robin", 3)
+	for _, up := range pool.upstreams {
+		up.healthy.Store(false)
+	}
+
+	got := pool.healthyUpstreams()
+	if len(got) != len(pool.upstreams) {
+		t.Fatalf("全部不健康时应回退为全量上游，got len=%d want %d", len(got), len(pool.upstreams))
+	}
+}
+
+// TestHealthyUpstreamsFiltersUnhealthy 验证存在健康上游时，healthyUpstreams
+// 只返回健康的那部分，不健康的会被排除。
+func TestHealthyUpstreamsFiltersUnhealthy(t *testing.T) {
+	pool := newTestPool("round_robin", 3)
+	pool.upstreams[1].healthy.Store(false)
+
+	got := pool.healthyUpstreams()
+	if len(got) != 2 {
+		t.Fatalf("应排除掉唯一不健康的上游，got len=%d", len(got))
+	}
+	for _, up := range got {
+		if up == pool.upstreams[1] {
+			t.Fatal("healthyUpstreams 不应包含被标记为不健康的上游")
+		}
+	}
+}
+
+// TestStartHealthChecksRecoversAfterThreshold 验证上游探测失败后被立即标记为
+// 不健康，并且需要连续 HealthyThreshold 次探测成功才会恢复为健康——单次成功不够。
+func TestStartHealthChecksRecoversAfterThreshold(t *testing.T) {
+	var healthy atomic.Bool
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatalf("解析测试后端地址失败: %v", err)
+	}
+
+	up := &upstream{url: backendURL}
+	up.healthy.Store(true)
+	interval := 30 * time.Millisecond
+	pool := &upstreamPool{
+		route: RouteConfig{
+			Path:        "/svc",
+			HealthCheck: &HealthCheckConfig{Path: "/healthz", Interval: interval, HealthyThreshold: 2},
+		},
+		upstreams: []*upstream{up},
+	}
+	pool.startHealthChecks()
+
+	waitFor(t, time.Second, func() bool { return !up.healthy.Load() })
+
+	healthy.Store(true)
+	// 单次探测成功不足以恢复（HealthyThreshold=2）：等待刚好一个探测周期后，
+	// okStreak 应为 1，尚不足以翻回健康。
+	waitFor(t, time.Second, func() bool { return up.okStreak.Load() >= 1 })
+	if up.healthy.Load() {
+		t.Fatal("单次探测成功不应立即恢复健康，需连续 HealthyThreshold 次")
+	}
+
+	waitFor(t, time.Second, func() bool { return up.healthy.Load() })
+}
+
+// TestReleaseOnCloseBodyDefersReleaseUntilClose 回归测试：least_conn 依赖
+// up.inFlight 及时反映真实的在途请求数；releaseOnCloseBody 必须把计数回写
+// 推迟到响应体被 Close（而不是 ModifyResponse 收到响应头那一刻），否则
+// 仍在流式传输大/慢响应体的上游会被过早当成空闲，继续被塞入新请求。
+func TestReleaseOnCloseBodyDefersReleaseUntilClose(t *testing.T) {
+	up := &upstream{url: &url.URL{Scheme: "http", Host: "backend"}}
+	up.inFlight.Store(1)
+	call := &upstreamCall{up: up, route: "/svc", start: time.Now()}
+
+	body := releaseOnCloseBody(io.NopCloser(strings.NewReader("streamed")), call)
+
+	if up.inFlight.Load() != 1 {
+		t.Fatal("包装响应体本身不应回写 in-flight 计数")
+	}
+
+	if _, err := io.ReadAll(body); err != nil {
+		t.Fatalf("读取响应体失败: %v", err)
+	}
+	if up.inFlight.Load() != 1 {
+		t.Fatal("读取响应体过程中不应回写 in-flight 计数，只有 Close 才应触发")
+	}
+
+	if err := body.Close(); err != nil {
+		t.Fatalf("Close() 返回错误: %v", err)
+	}
+	if up.inFlight.Load() != 0 {
+		t.Fatal("Close() 之后应回写 in-flight 计数")
+	}
+
+	// 重复 Close 不应重复回写（sync.Once 保护）。
+	if err := body.Close(); err != nil {
+		t.Fatalf("重复 Close() 返回错误: %v", err)
+	}
+	if up.inFlight.Load() != 0 {
+		t.Fatal("重复 Close() 不应再次回写 in-flight 计数")
+	}
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	t.Fatal("等待条件满足超时")
+}
+
+// newEchoUpgradeBackend 启动一个会 Hijack 连接、完成一次协议升级握手后
+// 把收到的字节原样加上 "echo:" 前缀回显的测试上游，用于模拟 WebSocket 之类
+// 的 Upgrade 后端。
+func newEchoUpgradeBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "hijack unsupported", http.StatusInternalServerError)
+			return
+		}
+		conn, _, err := hj.Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", r.Header.Get("Upgrade"))
+
+		buf := make([]byte, 1024)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				if _, werr := conn.Write(append([]byte("echo:"), buf[:n]...)); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}))
+}
+
+// TestReverseProxyUpgradeThroughFullMiddlewareChain 端到端回归测试：Upgrade
+// 请求（如 WebSocket 握手）经过 RouterMiddleware -> MetricsMiddleware ->
+// TimeoutMiddleware -> CircuitBreakerMiddleware 的完整链路后，仍然能被
+// httputil.ReverseProxy 正确 Hijack 并在客户端与上游之间双向转发字节。
+// 在 ResponseWriterWrapper 补上 Unwrap、TimeoutMiddleware 对 Upgrade 请求
+// 直接透传之前，这条链路上的任何一层包装都会让 Hijack 失败，整条请求退化成
+// 502 "can't switch protocols using non-Hijacker ResponseWriter type ..."。
+// TestDirectorAppendsRealRemoteAddrToForwardedFor 回归测试：director 拼接
+// X-Forwarded-For 时必须使用网关自己观察到的真实对端地址（req.RemoteAddr），
+// 而不是 clientIP(req)（它会优先读取客户端自己发来的 X-Forwarded-For），
+// 否则攻击者伪造的值会被原样回显而不是记录网关自身的连接视角。
+func TestDirectorAppendsRealRemoteAddrToForwardedFor(t *testing.T) {
+	var gotXFF string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotXFF = r.Header.Get("X-Forwarded-For")
+	}))
+	defer backend.Close()
+
+	route := RouteConfig{Path: "/svc", Target: Targets{backend.URL}}
+	pool, err := newUpstreamPool(route)
+	if err != nil {
+		t.Fatalf("newUpstreamPool 失败: %v", err)
+	}
+	rp := newReverseProxy(pool, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/svc", nil)
+	req.RemoteAddr = "203.0.113.9:5555"
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	rec := httptest.NewRecorder()
+	rp.ServeHTTP(rec, req)
+
+	// httputil.ReverseProxy 自身也会在 director 之后再追加一次真实对端地址
+	// 作为最后一跳，所以这里看到它出现两次：一次是 director 附加的，
+	// 一次是 ReverseProxy 的内置行为，两者都反映真实对端而非伪造值。
+	want := "10.0.0.1, 203.0.113.9, 203.0.113.9"
+	if gotXFF != want {
+		t.Fatalf("上游收到的 X-Forwarded-For = %q, want %q", gotXFF, want)
+	}
+}
+
+func TestReverseProxyUpgradeThroughFullMiddlewareChain(t *testing.T) {
+	backend := newEchoUpgradeBackend(t)
+	defer backend.Close()
+
+	route := RouteConfig{
+		Path:       "/ws",
+		Target:     Targets{backend.URL},
+		AuthScheme: "none",
+		Breaker: &BreakerConfig{
+			Window: time.Minute, MinRequests: 100, FailureRatio: 0.9,
+			OpenDuration: time.Minute, HalfOpenMaxProbes: 1,
+		},
+	}
+	cfg := &Config{
+		Port:              ":0",
+		Timeout:           time.Second,
+		NoRateLimitRoutes: []string{"/ws"},
+		Routes:            []RouteConfig{route},
+	}
+	cm := newTestConfigManager(cfg)
+
+	proxy, _ := newProxyHandler(cfg.Routes, nil)
+	handler := ChainMiddleware(proxy,
+		RouterMiddleware(cm), MetricsMiddleware(), TimeoutMiddleware(cm), CircuitBreakerMiddleware(cm))
+
+	gateway := httptest.NewServer(handler)
+	defer gateway.Close()
+
+	conn, err := net.Dial("tcp", gateway.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("拨号网关失败: %v", err)
+	}
+	defer conn.Close()
+
+	req, err := http.NewRequest(http.MethodGet, gateway.URL+"/ws", nil)
+	if err != nil {
+		t.Fatalf("构造请求失败: %v", err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test-echo")
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("写请求失败: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatalf("读取响应失败: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("状态码 = %d, want %d（完整中间件链应让 Hijack 正常工作）", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("写入升级后连接失败: %v", err)
+	}
+
+	want := "echo:hello"
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(reader, got); err != nil {
+		t.Fatalf("读取回显失败: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("回显内容 = %q, want %q", got, want)
+	}
+}
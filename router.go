@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// routeCtxKey 是匹配到的路由在请求上下文中的键。
+type routeCtxKey struct{}
+
+// matchedRoute 携带本次请求匹配到的路由模板及从路径中捕获的参数，
+// 供下游中间件（限流、熔断、日志、代理）按路由模板而非具体路径分类，
+// 从而保持指标标签基数稳定。
+type matchedRoute struct {
+	Route  *RouteConfig
+	Params map[string]string
+}
+
+// routeNode 是路由 Trie 的一个节点，按路径分段逐层匹配：
+// 静态分段、单个 :param 分段、或末尾的 *catchall 分段。
+type routeNode struct {
+	static    map[string]*routeNode
+	param     *routeNode
+	paramName string
+	catchAll  *routeNode
+	catchName string
+	routes    map[string]*RouteConfig // HTTP 方法 -> 路由，"*" 表示任意方法
+}
+
+// Router 是一个按 HTTP 方法 + 路径匹配路由的前缀树，
+// 支持形如 /users/:id 的路径参数与 /static/*filepath 的通配捕获。
+type Router struct {
+	root *routeNode
+}
+
+// newRouter 依据配置的路由列表构建 Trie。
+func newRouter(routes []RouteConfig) *Router {
+	root := &routeNode{}
+	for i := range routes {
+		route := &routes[i]
+		methods := route.Methods
+		if len(methods) == 0 {
+			methods = []string{"*"}
+		}
+		insertRoute(root, route, methods)
+	}
+	return &Router{root: root}
+}
+
+func insertRoute(root *routeNode, route *RouteConfig, methods []string) {
+	node := root
+	for _, seg := range splitPath(route.Path) {
+		switch {
+		case strings.HasPrefix(seg, ":"):
+			if node.param == nil {
+				node.param = &routeNode{paramName: seg[1:]}
+			}
+			node = node.param
+		case strings.HasPrefix(seg, "*"):
+			if node.catchAll == nil {
+				node.catchAll = &routeNode{catchName: seg[1:]}
+			}
+			node = node.catchAll
+		default:
+			if node.static == nil {
+				node.static = make(map[string]*routeNode)
+			}
+			child, ok := node.static[seg]
+			if !ok {
+				child = &routeNode{}
+				node.static[seg] = child
+			}
+			node = child
+		}
+	}
+
+	if node.routes == nil {
+		node.routes = make(map[string]*RouteConfig)
+	}
+	for _, m := range methods {
+		node.routes[strings.ToUpper(m)] = route
+	}
+}
+
+// Match 在 Trie 中查找与 method+path 匹配的路由，返回命中的路由配置
+// 及从路径中捕获的参数。
+func (rt *Router) Match(method, path string) (*RouteConfig, map[string]string, bool) {
+	segments := splitPath(path)
+	node := rt.root
+	var params map[string]string
+
+	for i, seg := range segments {
+		if node.static != nil {
+			if child, ok := node.static[seg]; ok {
+				node = child
+				continue
+			}
+		}
+		if node.param != nil {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[node.param.paramName] = seg
+			node = node.param
+			continue
+		}
+		if node.catchAll != nil {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[node.catchAll.catchName] = strings.Join(segments[i:], "/")
+			node = node.catchAll
+			break
+		}
+		return nil, nil, false
+	}
+
+	if node.routes == nil {
+		return nil, nil, false
+	}
+	if route, ok := node.routes[strings.ToUpper(method)]; ok {
+		return route, params, true
+	}
+	if route, ok := node.routes["*"]; ok {
+		return route, params, true
+	}
+	return nil, nil, false
+}
+
+// splitPath 把形如 "/users/:id/" 的路径切分为 ["users", ":id"]，忽略首尾空分段。
+func splitPath(path string) []string {
+	raw := strings.Split(path, "/")
+	segments := make([]string, 0, len(raw))
+	for _, s := range raw {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
+
+// RouterMiddleware 对每个请求做一次路由匹配，并把命中的路由模板/参数
+// 注入请求上下文，交由后续中间件与代理处理函数使用；未命中时直接返回 404。
+// 参数 cm 提供当前生效的配置快照；Trie 只在配置热更新后才会重建。
+//
+// 注意：newProxyHandler 构建的上游连接池目前仍在启动时一次性创建，
+// 热更新路由列表后，新增/删除的路由要到下次重启才会反映到代理层，
+// 这里只保证“匹配规则”本身是热的。
+func RouterMiddleware(cm *ConfigManager) Middleware {
+	var cache cachedDerived[*Router]
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			router := cache.get(cm.Current(), func(config *Config) *Router {
+				return newRouter(config.Routes)
+			})
+
+			route, params, ok := router.Match(r.Method, r.URL.Path)
+			if !ok {
+				http.Error(w, "404 Route Not Found", http.StatusNotFound)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), routeCtxKey{}, &matchedRoute{Route: route, Params: params})
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// matchedRouteFrom 取出 RouterMiddleware 注入的匹配结果。
+func matchedRouteFrom(r *http.Request) (*matchedRoute, bool) {
+	m, ok := r.Context().Value(routeCtxKey{}).(*matchedRoute)
+	return m, ok
+}
+
+// routeLabel 返回用于日志/指标的路由模板；理论上 RouterMiddleware 必然先于
+// 调用方执行，未匹配时退化为具体路径只是兜底，不应在正常链路中出现。
+func routeLabel(r *http.Request) string {
+	if m, ok := matchedRouteFrom(r); ok {
+		return m.Route.Path
+	}
+	return r.URL.Path
+}
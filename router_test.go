@@ -0,0 +1,133 @@
+package main
+
+import "testing"
+
+// TestRouterStaticMatch 验证静态路径匹配，及未命中方法时不误命中其它路由。
+func TestRouterStaticMatch(t *testing.T) {
+	users := &RouteConfig{Path: "/users"}
+	rt := newRouter([]RouteConfig{*users})
+
+	route, params, ok := rt.Match("GET", "/users")
+	if !ok || route.Path != "/users" {
+		t.Fatalf("静态路径应命中 /users，实际 ok=%v route=%v", ok, route)
+	}
+	if len(params) != 0 {
+		t.Fatalf("静态路径不应捕获任何参数，实际 %v", params)
+	}
+
+	if _, _, ok := rt.Match("GET", "/orders"); ok {
+		t.Fatal("未注册的路径不应命中")
+	}
+}
+
+// TestRouterParamSegment 验证 :param 分段能正确捕获路径参数。
+func TestRouterParamSegment(t *testing.T) {
+	rt := newRouter([]RouteConfig{{Path: "/users/:id"}})
+
+	route, params, ok := rt.Match("GET", "/users/42")
+	if !ok || route.Path != "/users/:id" {
+		t.Fatalf("应命中 /users/:id，实际 ok=%v route=%v", ok, route)
+	}
+	if params["id"] != "42" {
+		t.Fatalf("params[id] = %q, want 42", params["id"])
+	}
+}
+
+// TestRouterCatchAllSegment 验证 *catchall 分段能捕获剩余的完整路径。
+func TestRouterCatchAllSegment(t *testing.T) {
+	rt := newRouter([]RouteConfig{{Path: "/static/*filepath"}})
+
+	route, params, ok := rt.Match("GET", "/static/js/app.min.js")
+	if !ok || route.Path != "/static/*filepath" {
+		t.Fatalf("应命中 /static/*filepath，实际 ok=%v route=%v", ok, route)
+	}
+	if params["filepath"] != "js/app.min.js" {
+		t.Fatalf("params[filepath] = %q, want js/app.min.js", params["filepath"])
+	}
+}
+
+// TestRouterStaticBeatsParamAndCatchAll 验证同一层级下静态分段的优先级高于
+// :param 与 *catchall —— 这是 insertRoute/Match 隐含的匹配优先级，需要显式覆盖。
+func TestRouterStaticBeatsParamAndCatchAll(t *testing.T) {
+	rt := newRouter([]RouteConfig{
+		{Path: "/users/:id"},
+		{Path: "/users/me"},
+	})
+
+	route, _, ok := rt.Match("GET", "/users/me")
+	if !ok || route.Path != "/users/me" {
+		t.Fatalf("静态分段 /users/me 应优先于 /users/:id 命中，实际 ok=%v route=%v", ok, route)
+	}
+
+	route, params, ok := rt.Match("GET", "/users/7")
+	if !ok || route.Path != "/users/:id" || params["id"] != "7" {
+		t.Fatalf("非 me 的其它取值应落到 /users/:id，实际 ok=%v route=%v params=%v", ok, route, params)
+	}
+}
+
+// TestRouterMethodDispatch 验证同一路径下按方法分别注册路由时，
+// Match 会按请求方法挑选对应路由。
+func TestRouterMethodDispatch(t *testing.T) {
+	rt := newRouter([]RouteConfig{
+		{Path: "/users", Methods: []string{"GET"}},
+		{Path: "/users", Methods: []string{"POST"}},
+	})
+
+	route, _, ok := rt.Match("GET", "/users")
+	if !ok || len(route.Methods) != 1 || route.Methods[0] != "GET" {
+		t.Fatalf("GET /users 应命中 Methods=[GET] 的路由，实际 ok=%v route=%v", ok, route)
+	}
+
+	route, _, ok = rt.Match("post", "/users")
+	if !ok || len(route.Methods) != 1 || route.Methods[0] != "POST" {
+		t.Fatalf("方法匹配应忽略大小写，实际 ok=%v route=%v", ok, route)
+	}
+
+	if _, _, ok := rt.Match("DELETE", "/users"); ok {
+		t.Fatal("未注册的方法不应命中")
+	}
+}
+
+// TestRouterMethodFallbackToWildcard 验证未声明 Methods 的路由等价于 "*"，
+// 对任意方法都生效。
+func TestRouterMethodFallbackToWildcard(t *testing.T) {
+	rt := newRouter([]RouteConfig{{Path: "/ping"}})
+
+	for _, method := range []string{"GET", "POST", "DELETE"} {
+		if _, _, ok := rt.Match(method, "/ping"); !ok {
+			t.Fatalf("未声明 Methods 的路由应匹配任意方法，%s 未命中", method)
+		}
+	}
+}
+
+// TestRouterMethodSpecificAndWildcardCoexist 验证同一路径下，特定方法的路由
+// 与兜底的 "*" 路由可以共存：特定方法优先命中，其余方法落到 "*"。
+func TestRouterMethodSpecificAndWildcardCoexist(t *testing.T) {
+	rt := newRouter([]RouteConfig{
+		{Path: "/mixed", Methods: []string{"GET"}, QPS: 1},
+		{Path: "/mixed", QPS: 2},
+	})
+
+	route, _, ok := rt.Match("GET", "/mixed")
+	if !ok || route.QPS != 1 {
+		t.Fatalf("GET /mixed 应命中专属 GET 路由，实际 ok=%v route=%v", ok, route)
+	}
+
+	route, _, ok = rt.Match("POST", "/mixed")
+	if !ok || route.QPS != 2 {
+		t.Fatalf("POST /mixed 应落到兜底的 \"*\" 路由，实际 ok=%v route=%v", ok, route)
+	}
+}
+
+// TestSplitPathTrimsEmptySegments 验证 splitPath 会忽略首尾（以及连续）的
+// 空分段，使尾部斜杠等写法与不带斜杠等价。
+func TestSplitPathTrimsEmptySegments(t *testing.T) {
+	rt := newRouter([]RouteConfig{{Path: "/users/:id/"}})
+
+	if _, params, ok := rt.Match("GET", "/users/9"); !ok || params["id"] != "9" {
+		t.Fatalf("路由模板尾部斜杠不应影响匹配，实际 ok=%v params=%v", ok, params)
+	}
+	if _, _, ok := rt.Match("GET", "/users/9/"); !ok {
+		t.Fatal("请求路径尾部斜杠也应被忽略")
+	}
+}
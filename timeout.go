@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// timeoutResponseWriter 把下游处理器的所有写入缓冲在内存中，只有在超时之前
+// 正常结束时才会被一次性 flush 到真正的 ResponseWriter；一旦超时，后续写入
+// 被静默丢弃，从而避免超时后仍在运行的下游 goroutine 和超时响应同时写同一个
+// http.ResponseWriter（数据竞争 + 响应内容错乱），语义上对齐标准库的
+// http.TimeoutHandler。
+type timeoutResponseWriter struct {
+	mu          sync.Mutex
+	header      http.Header
+	buf         bytes.Buffer
+	statusCode  int
+	wroteHeader bool
+	timedOut    bool
+}
+
+func newTimeoutResponseWriter() *timeoutResponseWriter {
+	return &timeoutResponseWriter{header: make(http.Header), statusCode: http.StatusOK}
+}
+
+func (tw *timeoutResponseWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutResponseWriter) WriteHeader(statusCode int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	tw.statusCode = statusCode
+}
+
+func (tw *timeoutResponseWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.wroteHeader = true
+	return tw.buf.Write(b)
+}
+
+// markTimedOut 标记本次请求已超时，之后所有写入都会被丢弃。注意 wroteHeader
+// 只表示下游已经往 tw.buf 里写过第一个字节（例如正在流式拷贝一个慢响应体的
+// 半途），而不表示已经写完、更不表示已经有任何字节到达真正的 w —— 在
+// ctx.Done() 触发前，tw.flush(w) 从未被调用过，缓冲区里的内容还没有碰到真实
+// 连接。因此这里无条件标记超时，调用方无条件向 w 写 504，丢弃缓冲区即可，
+// 不能按 wroteHeader 来决定是否还要写超时响应（那样会在流式场景下既不 flush
+// 缓冲区也不写 504，客户端只会收到 Go 标准库兜底的空 200）。
+func (tw *timeoutResponseWriter) markTimedOut() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.timedOut = true
+}
+
+// flush 把缓冲的响应头与响应体一次性写入真正的 ResponseWriter；
+// 只应在确认下游处理器已正常结束（未超时）之后调用。
+func (tw *timeoutResponseWriter) flush(w http.ResponseWriter) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	dst := w.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+	w.WriteHeader(tw.statusCode)
+	w.Write(tw.buf.Bytes())
+}
+
+// isUpgradeRequest 判断请求是否在请求协议升级（如 WebSocket）。
+// httputil.ReverseProxy 处理这类请求时需要 http.NewResponseController(rw).Hijack()
+// 直接接管底层连接做双向字节转发，而 timeoutResponseWriter 把响应整个缓冲在
+// 内存里、既不是真正的连接也没有 Hijacker 实现，一旦包裹住就会让所有 Upgrade
+// 请求都失败并报 502。因此 Upgrade 请求要在 TimeoutMiddleware 这里直接跳过。
+func isUpgradeRequest(r *http.Request) bool {
+	return headerContainsToken(r.Header, "Connection", "Upgrade") && r.Header.Get("Upgrade") != ""
+}
+
+// headerContainsToken 判断某个按逗号分隔取值的请求头（如 Connection: keep-alive,
+// Upgrade）是否包含给定 token，大小写不敏感。
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TimeoutMiddleware 返回一个超时控制中间件，按 ConfigManager 当前快照的
+// Timeout 字段设置每次请求的超时时间。下游处理器在一个独立 goroutine 中运行，
+// 写入一个内存缓冲的 ResponseWriter；超时发生时直接向客户端返回 504，缓冲区
+// 被丢弃，不会有部分写入的响应体，也不会和仍在运行的下游 goroutine 竞争同一个
+// http.ResponseWriter。
+//
+// 下游处理器（包括 proxy.go 的反向代理）使用的是同一个带超时的请求上下文，
+// httputil.ReverseProxy 会在该上下文超时时主动取消正在进行的上游请求并中断
+// 响应体的拷贝，因此这里不需要再额外包装一层 context-aware 的 io.Reader。
+//
+// 协议升级（WebSocket 等）请求是例外：缓冲式包装器无法被 Hijack，这里直接
+// 透传原始 ResponseWriter、不再对其施加请求级超时——升级之后连接的生命周期
+// 由两端自行管理，不再是一问一答式的请求/响应。
+//
+// 参数:
+//   - cm: 提供当前配置快照的 ConfigManager
+//
+// 返回值:
+//   - Middleware: 包含超时控制逻辑的中间件
+func TimeoutMiddleware(cm *ConfigManager) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if isUpgradeRequest(r) {
+				next(w, r)
+				return
+			}
+
+			timeout := cm.Current().Timeout
+
+			// 基于请求自身的上下文创建带超时的子上下文，保留 RouterMiddleware
+			// 等上游中间件注入的上下文值（如匹配到的路由）
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+
+			tw := newTimeoutResponseWriter()
+			done := make(chan struct{})
+			go func() {
+				next(tw, r)
+				close(done)
+			}()
+
+			select {
+			case <-done:
+				tw.flush(w)
+			case <-ctx.Done():
+				tw.markTimedOut()
+				http.Error(w, "Request timeout", http.StatusGatewayTimeout)
+				log.Printf("[超时] %s %s | 超时时间：%.2fms", r.Method, r.URL.Path, timeout.Seconds()*1000)
+			}
+		}
+	}
+}
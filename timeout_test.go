@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestConfigManager 构造一个不依赖磁盘文件/fsnotify 的 ConfigManager，
+// 仅用于注入测试所需的配置快照。
+func newTestConfigManager(cfg *Config) *ConfigManager {
+	cm := &ConfigManager{}
+	cm.current.Store(cfg)
+	return cm
+}
+
+// TestTimeoutMiddlewareNormalCompletion 验证未超时的正常响应会被原样 flush
+// 到真正的 ResponseWriter。
+func TestTimeoutMiddlewareNormalCompletion(t *testing.T) {
+	cm := newTestConfigManager(&Config{Timeout: time.Second})
+	handler := TimeoutMiddleware(cm)(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/fast", nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("状态码 = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	if rec.Body.String() != "hello" {
+		t.Fatalf("响应体 = %q, want %q", rec.Body.String(), "hello")
+	}
+	if rec.Header().Get("X-Test") != "1" {
+		t.Fatalf("响应头未被 flush 到真正的 ResponseWriter")
+	}
+}
+
+// TestTimeoutMiddlewareSlowHeader 验证下游在写出任何字节之前就超过超时时间
+// （慢响应头场景）时，客户端收到 504 而不是挂起或空响应。
+func TestTimeoutMiddlewareSlowHeader(t *testing.T) {
+	cm := newTestConfigManager(&Config{Timeout: 20 * time.Millisecond})
+	release := make(chan struct{})
+	handler := TimeoutMiddleware(cm)(func(w http.ResponseWriter, r *http.Request) {
+		<-release // 模拟上游迟迟不返回响应头
+		w.WriteHeader(http.StatusOK)
+	})
+	defer close(release)
+
+	req := httptest.NewRequest(http.MethodGet, "/slow-header", nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("慢响应头场景下状态码 = %d, want %d", rec.Code, http.StatusGatewayTimeout)
+	}
+	if !strings.Contains(rec.Body.String(), "Request timeout") {
+		t.Fatalf("响应体未包含超时提示: %q", rec.Body.String())
+	}
+}
+
+// TestTimeoutMiddlewareSlowBody 验证下游已经开始流式写出响应体（第一个
+// chunk 已写入 tw.buf，tw.wroteHeader 变为 true）之后超时的场景：
+// markTimedOut 不能因为 wroteHeader 已经是 true 就放弃写 504，否则客户端
+// 只会收到 Go 标准库兜底的空 200（回归测试，对应本次修复的 bug）。
+func TestTimeoutMiddlewareSlowBody(t *testing.T) {
+	cm := newTestConfigManager(&Config{Timeout: 20 * time.Millisecond})
+	release := make(chan struct{})
+	handler := TimeoutMiddleware(cm)(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("first-chunk"))
+		<-release // 模拟上游响应体写到一半就卡住
+		w.Write([]byte("second-chunk"))
+	})
+	defer close(release)
+
+	req := httptest.NewRequest(http.MethodGet, "/slow-body", nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("慢响应体场景下状态码 = %d, want %d（回归：曾经会得到 %d 空响应）",
+			rec.Code, http.StatusGatewayTimeout, http.StatusOK)
+	}
+	if !strings.Contains(rec.Body.String(), "Request timeout") {
+		t.Fatalf("响应体未包含超时提示: %q", rec.Body.String())
+	}
+}